@@ -1,7 +1,54 @@
 package types
 
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidMoney = errors.New("invalid money amount")
+
 type Money int64
 
+// String renders minor units with two decimals, 12345 becomes "123.45".
+func (m Money) String() string {
+	sign := ""
+	value := uint64(m)
+	if m < 0 {
+		sign = "-"
+		value = uint64(-m)
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, value/100, value%100)
+}
+
+func ParseMoney(s string) (Money, error) {
+	value := strings.TrimPrefix(s, "-")
+	negative := value != s
+
+	units, cents := value, ""
+	if dot := strings.Index(value, "."); dot >= 0 {
+		units, cents = value[:dot], value[dot+1:]
+		if len(cents) == 0 || len(cents) > 2 {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+		}
+	}
+	if units == "" || strings.ContainsAny(units+cents, "+-") {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	cents += strings.Repeat("0", 2-len(cents))
+
+	amount, err := strconv.ParseInt(units+cents, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	if negative {
+		amount = -amount
+	}
+	return Money(amount), nil
+}
+
 type PaymentCategory string
 
 type PaymentStatus string
@@ -19,25 +66,78 @@ const (
 )
 
 type Payment struct {
-	ID        string
-	AccountID int64
-	Amount    Money
-	Category  PaymentCategory
-	Status    PaymentStatus
+	ID          string
+	AccountID   int64
+	Amount      Money
+	Category    PaymentCategory
+	Status      PaymentStatus
+	CreatedAt   time.Time
+	Description string
+	Fee         Money
 }
 
 type Phone string
 
+type Currency string
+
 type Account struct {
-	ID      int64
-	Phone   Phone
-	Balance Money
+	ID             int64
+	Phone          Phone
+	Balance        Money
+	Currency       Currency
+	Frozen         bool
+	Closed         bool
+	OverdraftLimit Money
+	Tags           []string
+	Label          string
+	CreatedAt      time.Time
 }
 
 type Favorite struct {
+	ID            string
+	AccountID     int64
+	Name          string
+	Amount        Money
+	Category      PaymentCategory
+	DestinationID int64
+}
+
+type ScheduledPayment struct {
+	ID        string
+	AccountID int64
+	Amount    Money
+	Category  PaymentCategory
+	At        time.Time
+	Status    PaymentStatus
+	PaymentID string
+}
+
+type RecurringPayment struct {
 	ID        string
 	AccountID int64
-	Name      string
 	Amount    Money
 	Category  PaymentCategory
+	Interval  time.Duration
+	NextRun   time.Time
+}
+
+type TransactionKind string
+
+const (
+	TransactionDeposit    TransactionKind = "deposit"
+	TransactionWithdrawal TransactionKind = "withdrawal"
+	TransactionPayment    TransactionKind = "payment"
+	TransactionRefund     TransactionKind = "refund"
+	TransactionTransfer   TransactionKind = "transfer"
+	TransactionReversal   TransactionKind = "reversal"
+)
+
+// Transaction is a single balance change, credits are positive and debits negative.
+type Transaction struct {
+	ID        int64
+	AccountID int64
+	Kind      TransactionKind
+	Amount    Money
+	Reference string
+	CreatedAt time.Time
 }
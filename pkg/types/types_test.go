@@ -0,0 +1,61 @@
+package types
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMoney_String(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{money: 12345, want: "123.45"},
+		{money: 5, want: "0.05"},
+		{money: 100, want: "1.00"},
+		{money: 0, want: "0.00"},
+		{money: -12345, want: "-123.45"},
+		{money: -5, want: "-0.05"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.money.String(); got != tt.want {
+				t.Errorf("String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Money
+		wantErr error
+	}{
+		{value: "123.45", want: 12345},
+		{value: "123.4", want: 12340},
+		{value: "123", want: 12300},
+		{value: "0.05", want: 5},
+		{value: "-123.45", want: -12345},
+		{value: "-0.05", want: -5},
+		{value: "1.234", wantErr: ErrInvalidMoney},
+		{value: "1.", wantErr: ErrInvalidMoney},
+		{value: ".5", wantErr: ErrInvalidMoney},
+		{value: "", wantErr: ErrInvalidMoney},
+		{value: "--1", wantErr: ErrInvalidMoney},
+		{value: "1.-5", wantErr: ErrInvalidMoney},
+		{value: "12a", wantErr: ErrInvalidMoney},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseMoney(tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ParseMoney() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseMoney() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
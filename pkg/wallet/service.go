@@ -2,16 +2,25 @@ package wallet
 
 import (
 	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/bdaler/wallet/pkg/types"
 	"github.com/google/uuid"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 var ErrPhoneRegistered = errors.New("phone already registered")
@@ -22,184 +31,3925 @@ var ErrPaymentNotFound = errors.New("payment not found")
 var ErrCannotRegisterAccount = errors.New("can not register account")
 var ErrCannotDepositAccount = errors.New("can not deposit account")
 var ErrFavoriteNotFound = errors.New("favorite payment not found")
+var ErrSameAccount = errors.New("source and destination accounts are the same")
+var ErrCorruptedExport = errors.New("corrupted export file")
+var ErrPaymentAlreadyRejected = errors.New("payment already rejected")
+var ErrBalanceLimitExceeded = errors.New("balance limit exceeded")
+var ErrAmountExceedsPayment = errors.New("amount exceeds refundable payment amount")
+var ErrInvalidFavoriteName = errors.New("favorite name must not be empty")
+var ErrDailyLimitExceeded = errors.New("daily spending limit exceeded")
+var ErrCurrencyMismatch = errors.New("accounts have different currencies")
+var ErrAccountFrozen = errors.New("account is frozen")
+var ErrInvalidPagination = errors.New("offset and limit must not be negative")
+var ErrCategoryLimitExceeded = errors.New("category spending limit exceeded")
+var ErrReversalPayment = errors.New("reversal payment can not be refunded")
+var ErrNoAccounts = errors.New("no accounts registered")
+var ErrInvalidInterval = errors.New("interval must be greater than zero")
+var ErrAccountLimitReached = errors.New("account limit reached")
+var ErrUnknownOperation = errors.New("unknown operation")
+var ErrInvalidTag = errors.New("tag must not be empty, padded with spaces or contain separators")
+var ErrDuplicatePaymentID = errors.New("payment id already exists")
+var ErrUnsupportedVersion = errors.New("unsupported export version")
+var ErrInvalidLabel = errors.New("label must not be empty or contain separators")
+var ErrLabelRegistered = errors.New("label already registered for phone")
+var ErrServiceClosed = errors.New("service is closed")
+var ErrInvalidRange = errors.New("range start is after its end")
+var ErrFavoriteLimitReached = errors.New("favorite limit reached")
+var ErrWouldGoBelowMinimum = errors.New("balance would go below minimum")
+var ErrTransferNotFound = errors.New("transfer not found")
+var ErrAmbiguousTransfer = errors.New("transfer id matches more than one transfer")
+var ErrTransferAlreadyReversed = errors.New("transfer already reversed")
+var ErrDepositTooSmall = errors.New("deposit below minimum amount")
+var ErrInvalidPercentile = errors.New("percentile must be between 0 and 100")
+var ErrInvalidPaymentID = errors.New("payment id must not be empty, padded with spaces or contain separators")
+var ErrAccountClosed = errors.New("account is closed")
+var ErrInconsistentState = errors.New("inconsistent service state")
+var ErrInvalidIdempotencyKey = errors.New("idempotency key must not be empty, padded with spaces or contain separators")
 
 type Service struct {
-	nextAccountID int64
-	accounts      []*types.Account
-	payments      []*types.Payment
-	favorites     []*types.Favorite
+	mu             sync.RWMutex
+	nextAccountID  int64
+	nextTxID       int64
+	accounts       []*types.Account
+	accountsByID   map[int64]*types.Account
+	payments       []*types.Payment
+	paymentsByID   map[string]*types.Payment
+	refunds        map[string]types.Money
+	reversals      map[string]string
+	dailyLimits    map[int64]types.Money
+	categoryLimits map[types.PaymentCategory]types.Money
+	depositKeys    map[string]bool
+	favorites      []*types.Favorite
+	scheduled      []*types.ScheduledPayment
+	recurring      []*types.RecurringPayment
+	transactions   []*types.Transaction
+	auditLog       []AuditEntry
+	closed         bool
+	lowBalance     []lowBalanceHook
+	pendingCalls   []lowBalanceCall
+	now            func() time.Time
+	idFunc         func() string
+
+	// MaxBalance caps the balance of every account against deposits, transfers, refunds and merges, 0 means unlimited.
+	MaxBalance types.Money
+	// BaseCurrency is assigned to accounts registered without a currency.
+	BaseCurrency types.Currency
+	// MaxAccounts caps the number of registered accounts, 0 means unlimited.
+	MaxAccounts int
+	// BlockDepositsWhenFrozen rejects deposits to frozen accounts.
+	BlockDepositsWhenFrozen bool
+	// FeeRate is charged on top of every payment, rounded up to a whole unit.
+	FeeRate float64
+	// MaxFavoritesPerAccount caps the favorites of every account, 0 means unlimited.
+	MaxFavoritesPerAccount int
+	// MinDeposit rejects smaller deposits, 0 means any positive amount.
+	MinDeposit types.Money
+}
+
+const (
+	OperationRegister = "register"
+	OperationDeposit  = "deposit"
+	OperationPay      = "pay"
+	OperationReject   = "reject"
+	OperationTransfer = "transfer"
+	OperationReassign = "reassign"
+	OperationRefund   = "refund"
+)
+
+// Operation is one step of a Batch, ToID is only used by transfers.
+type Operation struct {
+	Kind      string
+	AccountID int64
+	ToID      int64
+	Amount    types.Money
+	Category  types.PaymentCategory
+}
+
+type AuditEntry struct {
+	Operation string
+	AccountID int64
+	Amount    types.Money
+	Time      time.Time
+	// Detail describes changes that have no amount, such as the phones of a reassignment.
+	Detail string
+}
+
+// AccountView is the public form of an account.
+type AccountView struct {
+	ID      int64       `json:"id"`
+	Phone   types.Phone `json:"phone"`
+	Balance types.Money `json:"balance"`
+}
+
+type Summary struct {
+	Spent      types.Money
+	Deposited  types.Money
+	Payments   int
+	ByCategory map[types.PaymentCategory]types.Money
+}
+
+type CategorySpend struct {
+	Category types.PaymentCategory
+	Total    types.Money
+}
+
+type lowBalanceHook struct {
+	threshold types.Money
+	fn        func(account *types.Account)
+}
+
+type lowBalanceCall struct {
+	fn      func(account *types.Account)
+	account *types.Account
+}
+
+type Option func(*Service)
+
+func NewService(opts ...Option) *Service {
+	s := &Service{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// WithStartID makes the first registered account get the given ID.
+func WithStartID(ID int64) Option {
+	return func(s *Service) {
+		s.nextAccountID = ID - 1
+	}
+}
+
+func WithMaxBalance(limit types.Money) Option {
+	return func(s *Service) {
+		s.MaxBalance = limit
+	}
+}
+
+func WithMaxAccounts(limit int) Option {
+	return func(s *Service) {
+		s.MaxAccounts = limit
+	}
+}
+
+func WithBlockDepositsWhenFrozen(block bool) Option {
+	return func(s *Service) {
+		s.BlockDepositsWhenFrozen = block
+	}
+}
+
+func WithFeeRate(rate float64) Option {
+	return func(s *Service) {
+		s.FeeRate = rate
+	}
+}
+
+func WithMaxFavoritesPerAccount(limit int) Option {
+	return func(s *Service) {
+		s.MaxFavoritesPerAccount = limit
+	}
+}
+
+func WithMinDeposit(amount types.Money) Option {
+	return func(s *Service) {
+		s.MinDeposit = amount
+	}
+}
+
+func WithBaseCurrency(currency types.Currency) Option {
+	return func(s *Service) {
+		s.BaseCurrency = currency
+	}
+}
+
+func WithIDGenerator(idFunc func() string) Option {
+	return func(s *Service) {
+		s.idFunc = idFunc
+	}
+}
+
+func WithClock(now func() time.Time) Option {
+	return func(s *Service) {
+		s.now = now
+	}
+}
+
+func (s *Service) SetMaxBalance(limit types.Money) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.MaxBalance = limit
+}
+
+func (s *Service) SetIDGenerator(idFunc func() string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idFunc = idFunc
+}
+
+func (s *Service) newID() string {
+	if s.idFunc != nil {
+		return s.idFunc()
+	}
+	return uuid.New().String()
+}
+
+func (s *Service) currentTime() time.Time {
+	if s.now != nil {
+		return s.now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (s *Service) audit(operation string, accountID int64, amount types.Money) {
+	s.auditDetail(operation, accountID, amount, "")
+}
+
+func (s *Service) auditDetail(operation string, accountID int64, amount types.Money, detail string) {
+	s.auditLog = append(s.auditLog, AuditEntry{
+		Operation: operation,
+		AccountID: accountID,
+		Amount:    amount,
+		Time:      s.currentTime(),
+		Detail:    detail,
+	})
+}
+
+func (s *Service) record(accountID int64, kind types.TransactionKind, amount types.Money, reference string) {
+	s.nextTxID++
+	s.transactions = append(s.transactions, &types.Transaction{
+		ID:        s.nextTxID,
+		AccountID: accountID,
+		Kind:      kind,
+		Amount:    amount,
+		Reference: reference,
+		CreatedAt: s.currentTime(),
+	})
+}
+
+// Transactions returns every balance change of the account, oldest first.
+func (s *Service) Transactions(accountID int64) ([]*types.Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+	return s.transactionsByAccount(accountID), nil
+}
+
+func (s *Service) transactionsByAccount(accountID int64) []*types.Transaction {
+	transactions := make([]*types.Transaction, 0)
+	for _, transaction := range s.transactions {
+		if transaction.AccountID == accountID {
+			transactions = append(transactions, transaction)
+		}
+	}
+	return transactions
+}
+
+// AuditLog returns the recorded operations in the order they happened.
+func (s *Service) AuditLog() []AuditEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]AuditEntry, len(s.auditLog))
+	copy(entries, s.auditLog)
+	return entries
+}
+
+func (s *Service) ClearAuditLog() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+	s.auditLog = nil
+	return nil
+}
+
+func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.registerAccount(phone, s.BaseCurrency)
+}
+
+func (s *Service) RegisterAccountWithCurrency(phone types.Phone, currency types.Currency) (*types.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.registerAccount(phone, currency)
+}
+
+// registerAccount adds the unlabelled account of the phone, sub-accounts of the phone do not count as registered.
+func (s *Service) registerAccount(phone types.Phone, currency types.Currency) (*types.Account, error) {
+	phone = normalizePhone(phone)
+	if _, err := s.findAccountByLabel(phone, ""); err == nil {
+		return nil, ErrPhoneRegistered
+	}
+	return s.createAccount(phone, currency, "")
+}
+
+// EnsureAccount returns the unlabelled account registered for the phone, registering it first if there is none.
+func (s *Service) EnsureAccount(phone types.Phone) (account *types.Account, created bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, err = s.findAccountByLabel(phone, "")
+	if err == nil {
+		return account, false, nil
+	}
+	account, err = s.registerAccount(phone, s.BaseCurrency)
+	if err != nil {
+		return nil, false, err
+	}
+	return account, true, nil
+}
+
+// RegisterSubAccount adds another wallet for the phone, told apart from the others by its label.
+func (s *Service) RegisterSubAccount(phone types.Phone, label string) (*types.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if label == "" || strings.ContainsAny(label, ";|\n") {
+		return nil, ErrInvalidLabel
+	}
+	phone = normalizePhone(phone)
+	if _, err := s.findAccountByLabel(phone, label); err == nil {
+		return nil, ErrLabelRegistered
+	}
+	return s.createAccount(phone, s.BaseCurrency, label)
+}
+
+func (s *Service) createAccount(phone types.Phone, currency types.Currency, label string) (*types.Account, error) {
+	if s.closed {
+		return nil, ErrServiceClosed
+	}
+	if s.MaxAccounts > 0 && len(s.accounts) >= s.MaxAccounts {
+		return nil, ErrAccountLimitReached
+	}
+	s.nextAccountID++
+	account := &types.Account{
+		ID:        s.nextAccountID,
+		Phone:     phone,
+		Balance:   0,
+		Currency:  currency,
+		Label:     label,
+		CreatedAt: s.currentTime(),
+	}
+	s.addAccount(account)
+	s.audit(OperationRegister, account.ID, 0)
+	return account, nil
+}
+
+func (s *Service) addAccount(account *types.Account) {
+	s.accounts = append(s.accounts, account)
+	s.indexAccount(account)
+}
+
+func (s *Service) indexAccount(account *types.Account) {
+	if s.accountsByID == nil {
+		s.accountsByID = make(map[int64]*types.Account)
+	}
+	if _, ok := s.accountsByID[account.ID]; !ok {
+		s.accountsByID[account.ID] = account
+	}
+}
+
+func (s *Service) addPayment(payment *types.Payment) {
+	s.payments = append(s.payments, payment)
+	s.indexPayment(payment)
+}
+
+func (s *Service) indexPayment(payment *types.Payment) {
+	if s.paymentsByID == nil {
+		s.paymentsByID = make(map[string]*types.Payment)
+	}
+	if _, ok := s.paymentsByID[payment.ID]; !ok {
+		s.paymentsByID[payment.ID] = payment
+	}
+}
+
+func (s *Service) reindex() {
+	s.accountsByID = make(map[int64]*types.Account, len(s.accounts))
+	for _, account := range s.accounts {
+		s.indexAccount(account)
+	}
+
+	s.paymentsByID = make(map[string]*types.Payment, len(s.payments))
+	for _, payment := range s.payments {
+		s.indexPayment(payment)
+	}
+}
+
+func (s *Service) UpdatePhone(accountID int64, newPhone types.Phone) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	if acc, err := s.findAccountByPhone(newPhone); err == nil && acc.ID != accountID {
+		return ErrPhoneRegistered
+	}
+
+	account.Phone = normalizePhone(newPhone)
+	return nil
+}
+
+// ReassignAccount moves the account to a new owner's phone and records the ownership change.
+func (s *Service) ReassignAccount(accountID int64, newPhone types.Phone) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return fmt.Errorf("reassign: %w", err)
+	}
+
+	if _, err := s.findAccountByPhone(newPhone); err == nil {
+		return fmt.Errorf("reassign: phone %s: %w", newPhone, ErrPhoneRegistered)
+	}
+
+	oldPhone := account.Phone
+	account.Phone = normalizePhone(newPhone)
+	s.auditDetail(OperationReassign, accountID, 0, fmt.Sprintf("from %s to %s", oldPhone, account.Phone))
+	return nil
+}
+
+func (s *Service) FreezeAccount(accountID int64) error {
+	return s.setFrozen(accountID, true)
+}
+
+func (s *Service) UnfreezeAccount(accountID int64) error {
+	return s.setFrozen(accountID, false)
+}
+
+func (s *Service) setFrozen(accountID int64, frozen bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	if account.Closed && !frozen {
+		return fmt.Errorf("account %d: %w", accountID, ErrAccountClosed)
+	}
+
+	account.Frozen = frozen
+	return nil
+}
+
+func (s *Service) AddTag(accountID int64, tag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	// Exports trim spaces around fields, so a padded tag would not survive a round trip.
+	if tag == "" || tag != strings.TrimSpace(tag) || strings.ContainsAny(tag, ",;|\n") {
+		return fmt.Errorf("tag %q: %w", tag, ErrInvalidTag)
+	}
+
+	if hasTag(account, tag) {
+		return nil
+	}
+	account.Tags = append(account.Tags, tag)
+	return nil
+}
+
+func (s *Service) RemoveTag(accountID int64, tag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	tags := make([]string, 0, len(account.Tags))
+	for _, t := range account.Tags {
+		if t != tag {
+			tags = append(tags, t)
+		}
+	}
+	if len(tags) == 0 {
+		tags = nil
+	}
+	account.Tags = tags
+	return nil
+}
+
+func (s *Service) AccountsByTag(tag string) []*types.Account {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := make([]*types.Account, 0)
+	for _, account := range s.accounts {
+		if hasTag(account, tag) {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+func hasTag(account *types.Account, tag string) bool {
+	for _, t := range account.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTags(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func (s *Service) Deposit(accountID int64, amount types.Money) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deposit(accountID, amount)
+}
+
+func (s *Service) deposit(accountID int64, amount types.Money) error {
+	if s.closed {
+		return fmt.Errorf("deposit: %w", ErrServiceClosed)
+	}
+	if amount <= 0 {
+		return fmt.Errorf("deposit: amount %d: %w", amount, ErrAmountMustBePositive)
+	}
+
+	if amount < s.MinDeposit {
+		return fmt.Errorf("deposit: amount %d under minimum %d: %w", amount, s.MinDeposit, ErrDepositTooSmall)
+	}
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return fmt.Errorf("deposit: %w", err)
+	}
+
+	if account.Closed {
+		return fmt.Errorf("deposit: account %d: %w", accountID, ErrAccountClosed)
+	}
+
+	if s.BlockDepositsWhenFrozen && account.Frozen {
+		return fmt.Errorf("deposit: account %d: %w", accountID, ErrAccountFrozen)
+	}
+
+	if err := s.checkMaxBalance(account, amount); err != nil {
+		return fmt.Errorf("deposit: %w", err)
+	}
+
+	account.Balance += amount
+	s.record(accountID, types.TransactionDeposit, amount, "")
+	s.audit(OperationDeposit, accountID, amount)
+	return nil
+}
+
+// BulkDeposit credits every account, skipping unknown ones, and returns how many were credited.
+// Any other error undoes the deposits already made, like Batch does.
+func (s *Service) BulkDeposit(accountIDs []int64, amount types.Money) (applied int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, ErrServiceClosed
+	}
+
+	if amount <= 0 {
+		return 0, fmt.Errorf("deposit: amount %d: %w", amount, ErrAmountMustBePositive)
+	}
+
+	if amount < s.MinDeposit {
+		return 0, fmt.Errorf("deposit: amount %d under minimum %d: %w", amount, s.MinDeposit, ErrDepositTooSmall)
+	}
+
+	credited := make([]*types.Account, 0, len(accountIDs))
+	transactionsLen := len(s.transactions)
+	nextTxID := s.nextTxID
+	auditLen := len(s.auditLog)
+	for _, accountID := range accountIDs {
+		err := s.deposit(accountID, amount)
+		if errors.Is(err, ErrAccountNotFound) {
+			continue
+		}
+		if err != nil {
+			for _, account := range credited {
+				account.Balance -= amount
+			}
+			s.transactions = s.transactions[:transactionsLen]
+			s.nextTxID = nextTxID
+			s.auditLog = s.auditLog[:auditLen]
+			return 0, err
+		}
+		credited = append(credited, s.accountsByID[accountID])
+		applied++
+	}
+	return applied, nil
+}
+
+// DepositIdempotent credits the account once per key, repeated keys are ignored.
+func (s *Service) DepositIdempotent(accountID int64, amount types.Money, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	// A padded key comes back trimmed from an export and would let the deposit be replayed.
+	if key == "" || key != strings.TrimSpace(key) || strings.ContainsAny(key, ";|") {
+		return fmt.Errorf("deposit: key %q: %w", key, ErrInvalidIdempotencyKey)
+	}
+
+	if s.depositKeys[key] {
+		return nil
+	}
+
+	err := s.deposit(accountID, amount)
+	if err != nil {
+		return err
+	}
+
+	if s.depositKeys == nil {
+		s.depositKeys = make(map[string]bool)
+	}
+	s.depositKeys[key] = true
+	return nil
+}
+
+func (s *Service) Withdraw(accountID int64, amount types.Money) error {
+	s.mu.Lock()
+	defer s.unlockAndNotify()
+	return s.withdraw(accountID, amount)
+}
+
+func (s *Service) withdraw(accountID int64, amount types.Money) error {
+	if s.closed {
+		return fmt.Errorf("withdraw: %w", ErrServiceClosed)
+	}
+	if amount <= 0 {
+		return fmt.Errorf("withdraw: amount %d: %w", amount, ErrAmountMustBePositive)
+	}
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return fmt.Errorf("withdraw: %w", err)
+	}
+
+	if account.Closed {
+		return fmt.Errorf("withdraw: account %d: %w", accountID, ErrAccountClosed)
+	}
+
+	if account.Frozen {
+		return fmt.Errorf("withdraw: account %d: %w", accountID, ErrAccountFrozen)
+	}
+
+	if available(account) < amount {
+		return fmt.Errorf("withdraw: account %d short by %d: %w", accountID, amount-available(account), ErrNotEnoughBalance)
+	}
+
+	before := account.Balance
+	account.Balance -= amount
+	s.noteLowBalance(account, before)
+	s.record(accountID, types.TransactionWithdrawal, -amount, "")
+	return nil
+}
+
+// OnLowBalance registers fn to be called when a payment or withdrawal takes an account below threshold.
+// Callbacks run after the lock is released, so they may call back into the service.
+func (s *Service) OnLowBalance(threshold types.Money, fn func(account *types.Account)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lowBalance = append(s.lowBalance, lowBalanceHook{threshold: threshold, fn: fn})
+}
+
+// noteLowBalance queues the callbacks whose threshold the account has just gone below.
+func (s *Service) noteLowBalance(account *types.Account, before types.Money) {
+	for _, hook := range s.lowBalance {
+		if before >= hook.threshold && account.Balance < hook.threshold {
+			s.pendingCalls = append(s.pendingCalls, lowBalanceCall{fn: hook.fn, account: account})
+		}
+	}
+}
+
+// unlockAndNotify releases the lock, then runs the low balance callbacks queued while it was held.
+// Every method that pays or withdraws defers it instead of s.mu.Unlock.
+func (s *Service) unlockAndNotify() {
+	calls := s.pendingCalls
+	s.pendingCalls = nil
+	s.mu.Unlock()
+
+	for _, call := range calls {
+		call.fn(call.account)
+	}
+}
+
+// checkMaxBalance refuses any credit that would take the account over MaxBalance.
+func (s *Service) checkMaxBalance(account *types.Account, amount types.Money) error {
+	if s.MaxBalance > 0 && amount > 0 && account.Balance+amount > s.MaxBalance {
+		return fmt.Errorf("account %d over limit by %d: %w", account.ID, account.Balance+amount-s.MaxBalance, ErrBalanceLimitExceeded)
+	}
+	return nil
+}
+
+// closedAccount returns the first of the accounts that is closed, or nil.
+func closedAccount(accounts ...*types.Account) *types.Account {
+	for _, account := range accounts {
+		if account.Closed {
+			return account
+		}
+	}
+	return nil
+}
+
+// available is what the account can spend including its overdraft.
+func available(account *types.Account) types.Money {
+	return account.Balance + account.OverdraftLimit
+}
+
+func (s *Service) SetOverdraftLimit(accountID int64, limit types.Money) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	if limit < 0 {
+		limit = 0
+	}
+	account.OverdraftLimit = limit
+	return nil
+}
+
+// Transfer returns the transfer ID that ReverseTransfer accepts.
+func (s *Service) Transfer(fromID, toID int64, amount types.Money) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transfer(fromID, toID, amount)
+}
+
+func (s *Service) transfer(fromID, toID int64, amount types.Money) (string, error) {
+	if s.closed {
+		return "", fmt.Errorf("transfer: %w", ErrServiceClosed)
+	}
+	if amount <= 0 {
+		return "", fmt.Errorf("transfer: amount %d: %w", amount, ErrAmountMustBePositive)
+	}
+
+	if fromID == toID {
+		return "", fmt.Errorf("transfer: account %d: %w", fromID, ErrSameAccount)
+	}
+
+	from, err := s.findAccountByID(fromID)
+	if err != nil {
+		return "", fmt.Errorf("transfer: %w", err)
+	}
+
+	to, err := s.findAccountByID(toID)
+	if err != nil {
+		return "", fmt.Errorf("transfer: %w", err)
+	}
+
+	if account := closedAccount(from, to); account != nil {
+		return "", fmt.Errorf("transfer: account %d: %w", account.ID, ErrAccountClosed)
+	}
+
+	if from.Frozen {
+		return "", fmt.Errorf("transfer: account %d: %w", fromID, ErrAccountFrozen)
+	}
+
+	if from.Currency != to.Currency {
+		return "", fmt.Errorf("transfer: %q to %q: %w", from.Currency, to.Currency, ErrCurrencyMismatch)
+	}
+
+	if from.Balance < amount {
+		return "", fmt.Errorf("transfer: account %d short by %d: %w", fromID, amount-from.Balance, ErrNotEnoughBalance)
+	}
+
+	if err := s.checkMaxBalance(to, amount); err != nil {
+		return "", fmt.Errorf("transfer: %w", err)
+	}
+
+	transferID := s.newID()
+	from.Balance -= amount
+	to.Balance += amount
+	s.record(fromID, types.TransactionTransfer, -amount, transferID)
+	s.record(toID, types.TransactionTransfer, amount, transferID)
+	s.audit(OperationTransfer, fromID, amount)
+	return transferID, nil
+}
+
+// ReverseTransfer moves the money of a transfer back while the destination still holds it.
+func (s *Service) ReverseTransfer(transferID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	if transferID == "" {
+		return fmt.Errorf("reverse: transfer %q: %w", transferID, ErrTransferNotFound)
+	}
+
+	var debit, credit *types.Transaction
+	for _, transaction := range s.transactions {
+		if transaction.Reference != transferID {
+			continue
+		}
+		switch {
+		case transaction.Kind == types.TransactionReversal:
+			return fmt.Errorf("reverse: transfer %s: %w", transferID, ErrTransferAlreadyReversed)
+		case transaction.Kind == types.TransactionTransfer && transaction.Amount < 0:
+			if debit != nil {
+				return fmt.Errorf("reverse: transfer %s: %w", transferID, ErrAmbiguousTransfer)
+			}
+			debit = transaction
+		case transaction.Kind == types.TransactionTransfer:
+			if credit != nil {
+				return fmt.Errorf("reverse: transfer %s: %w", transferID, ErrAmbiguousTransfer)
+			}
+			credit = transaction
+		}
+	}
+	if debit == nil || credit == nil {
+		return fmt.Errorf("reverse: transfer %s: %w", transferID, ErrTransferNotFound)
+	}
+
+	from, err := s.findAccountByID(credit.AccountID)
+	if err != nil {
+		return fmt.Errorf("reverse: %w", err)
+	}
+
+	to, err := s.findAccountByID(debit.AccountID)
+	if err != nil {
+		return fmt.Errorf("reverse: %w", err)
+	}
+
+	// Reversing the sweep of CloseAccount, or any transfer of a closed account, would reopen it.
+	if account := closedAccount(from, to); account != nil {
+		return fmt.Errorf("reverse: account %d: %w", account.ID, ErrAccountClosed)
+	}
+
+	amount := credit.Amount
+	if from.Balance < amount {
+		return fmt.Errorf("reverse: account %d short by %d: %w", from.ID, amount-from.Balance, ErrNotEnoughBalance)
+	}
+
+	if err := s.checkMaxBalance(to, amount); err != nil {
+		return fmt.Errorf("reverse: %w", err)
+	}
+
+	from.Balance -= amount
+	to.Balance += amount
+	s.record(from.ID, types.TransactionReversal, -amount, transferID)
+	s.record(to.ID, types.TransactionReversal, amount, transferID)
+	s.audit(OperationTransfer, from.ID, amount)
+	return nil
+}
+
+// CloseAccount sweeps the whole balance to the destination and closes the account for good,
+// it takes no more money in or out and can not be unfrozen.
+func (s *Service) CloseAccount(accountID, destinationID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	if accountID == destinationID {
+		return fmt.Errorf("close: account %d: %w", accountID, ErrSameAccount)
+	}
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	destination, err := s.findAccountByID(destinationID)
+	if err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	if account.Closed {
+		return fmt.Errorf("close: account %d: %w", accountID, ErrAccountClosed)
+	}
+
+	if destination.Closed {
+		return fmt.Errorf("close: account %d: %w", destinationID, ErrAccountClosed)
+	}
+
+	if account.Frozen {
+		return fmt.Errorf("close: account %d: %w", accountID, ErrAccountFrozen)
+	}
+
+	if account.Currency != destination.Currency {
+		return fmt.Errorf("close: %q to %q: %w", account.Currency, destination.Currency, ErrCurrencyMismatch)
+	}
+
+	if account.Balance < 0 {
+		return fmt.Errorf("close: account %d short by %d: %w", accountID, -account.Balance, ErrNotEnoughBalance)
+	}
+
+	if err := s.checkMaxBalance(destination, account.Balance); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	if account.Balance > 0 {
+		destination.Balance += account.Balance
+		sweepID := s.newID()
+		s.record(accountID, types.TransactionTransfer, -account.Balance, sweepID)
+		s.record(destinationID, types.TransactionTransfer, account.Balance, sweepID)
+		s.audit(OperationTransfer, accountID, account.Balance)
+	}
+	account.Balance = 0
+	account.Frozen = true
+	account.Closed = true
+	return nil
+}
+
+// Batch applies all operations or none of them, returning the first error.
+func (s *Service) Batch(ops []Operation) error {
+	s.mu.Lock()
+	defer s.unlockAndNotify()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	balances := make(map[int64]types.Money)
+	remember := func(accountID int64) {
+		if _, ok := balances[accountID]; ok {
+			return
+		}
+		if account, err := s.findAccountByID(accountID); err == nil {
+			balances[accountID] = account.Balance
+		}
+	}
+	paymentsLen := len(s.payments)
+	transactionsLen := len(s.transactions)
+	nextTxID := s.nextTxID
+	auditLen := len(s.auditLog)
+	pendingLen := len(s.pendingCalls)
+
+	for i, op := range ops {
+		var err error
+		switch op.Kind {
+		case OperationPay:
+			remember(op.AccountID)
+			_, err = s.pay(op.AccountID, op.Amount, op.Category)
+		case OperationDeposit:
+			remember(op.AccountID)
+			err = s.deposit(op.AccountID, op.Amount)
+		case OperationTransfer:
+			remember(op.AccountID)
+			remember(op.ToID)
+			_, err = s.transfer(op.AccountID, op.ToID, op.Amount)
+		default:
+			err = fmt.Errorf("%q: %w", op.Kind, ErrUnknownOperation)
+		}
+		if err == nil {
+			continue
+		}
+
+		for accountID, balance := range balances {
+			s.accountsByID[accountID].Balance = balance
+		}
+		for _, payment := range s.payments[paymentsLen:] {
+			delete(s.paymentsByID, payment.ID)
+		}
+		s.payments = s.payments[:paymentsLen]
+		s.transactions = s.transactions[:transactionsLen]
+		s.nextTxID = nextTxID
+		s.auditLog = s.auditLog[:auditLen]
+		s.pendingCalls = s.pendingCalls[:pendingLen]
+		return fmt.Errorf("batch: operation %d: %w", i, err)
+	}
+	return nil
+}
+
+func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.unlockAndNotify()
+	return s.pay(accountID, amount, category)
+}
+
+func (s *Service) PayWithNote(accountID int64, amount types.Money, category types.PaymentCategory, note string) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.unlockAndNotify()
+
+	payment, err := s.pay(accountID, amount, category)
+	if err != nil {
+		return nil, err
+	}
+	payment.Description = note
+	return payment, nil
+}
+
+// PayIfBalance pays only when the balance left after the payment and its fee is at least minRemaining.
+func (s *Service) PayIfBalance(accountID int64, amount types.Money, minRemaining types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.unlockAndNotify()
+
+	if amount <= 0 {
+		return nil, fmt.Errorf("pay: amount %d: %w", amount, ErrAmountMustBePositive)
+	}
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, fmt.Errorf("pay: %w", err)
+	}
+
+	if remaining := account.Balance - amount - s.fee(amount); remaining < minRemaining {
+		return nil, fmt.Errorf("pay: account %d would keep %d of %d: %w", accountID, remaining, minRemaining, ErrWouldGoBelowMinimum)
+	}
+	return s.pay(accountID, amount, category)
+}
+
+// ReservePaymentID returns an ID to be used later with PayWithID.
+func (s *Service) ReservePaymentID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newID()
+}
+
+func (s *Service) PayWithID(paymentID string, accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.unlockAndNotify()
+
+	// The ID is written to exports as is, like an idempotency key.
+	if paymentID == "" || paymentID != strings.TrimSpace(paymentID) || strings.ContainsAny(paymentID, ";|\n") {
+		return nil, fmt.Errorf("pay: payment %q: %w", paymentID, ErrInvalidPaymentID)
+	}
+
+	if _, err := s.findPaymentByID(paymentID); err == nil {
+		return nil, fmt.Errorf("pay: payment %s: %w", paymentID, ErrDuplicatePaymentID)
+	}
+	return s.payWithID(paymentID, accountID, amount, category)
+}
+
+func (s *Service) pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	return s.payWithID("", accountID, amount, category)
+}
+
+// payWithID generates a new ID when paymentID is empty.
+func (s *Service) payWithID(paymentID string, accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	if s.closed {
+		return nil, fmt.Errorf("pay: %w", ErrServiceClosed)
+	}
+	if amount <= 0 {
+		return nil, fmt.Errorf("pay: amount %d: %w", amount, ErrAmountMustBePositive)
+	}
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, fmt.Errorf("pay: %w", err)
+	}
+
+	now := s.currentTime()
+	fee := s.fee(amount)
+	err = s.checkPayable(account, amount, fee, category, now)
+	if err != nil {
+		return nil, err
+	}
+
+	before := account.Balance
+	account.Balance -= amount + fee
+	s.noteLowBalance(account, before)
+	if paymentID == "" {
+		paymentID = s.newID()
+	}
+	payment := &types.Payment{
+		ID:        paymentID,
+		AccountID: accountID,
+		Amount:    amount,
+		Category:  category,
+		Status:    types.PaymentStatusInProgress,
+		CreatedAt: now,
+		Fee:       fee,
+	}
+
+	s.addPayment(payment)
+	s.record(accountID, types.TransactionPayment, -(amount + fee), paymentID)
+	s.audit(OperationPay, accountID, amount)
+	return payment, nil
+}
+
+func (s *Service) SchedulePayment(accountID int64, amount types.Money, category types.PaymentCategory, at time.Time) (scheduleID string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return "", ErrServiceClosed
+	}
+
+	if amount <= 0 {
+		return "", fmt.Errorf("schedule: amount %d: %w", amount, ErrAmountMustBePositive)
+	}
+
+	_, err = s.findAccountByID(accountID)
+	if err != nil {
+		return "", fmt.Errorf("schedule: %w", err)
+	}
+
+	scheduled := &types.ScheduledPayment{
+		ID:        s.newID(),
+		AccountID: accountID,
+		Amount:    amount,
+		Category:  category,
+		At:        at.UTC(),
+		Status:    types.PaymentStatusInProgress,
+	}
+	s.scheduled = append(s.scheduled, scheduled)
+	return scheduled.ID, nil
+}
+
+// RunDue executes pending schedules due at now in time order, schedules that can not be paid are marked failed.
+func (s *Service) RunDue(now time.Time) ([]*types.Payment, error) {
+	s.mu.Lock()
+	defer s.unlockAndNotify()
+
+	if s.closed {
+		return nil, ErrServiceClosed
+	}
+
+	due := make([]*types.ScheduledPayment, 0)
+	for _, scheduled := range s.scheduled {
+		if scheduled.Status == types.PaymentStatusInProgress && !scheduled.At.After(now) {
+			due = append(due, scheduled)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].At.Before(due[j].At)
+	})
+
+	payments := make([]*types.Payment, 0, len(due))
+	for _, scheduled := range due {
+		payment, err := s.pay(scheduled.AccountID, scheduled.Amount, scheduled.Category)
+		if err != nil {
+			log.Print(err)
+			scheduled.Status = types.PaymentStatusFail
+			continue
+		}
+		scheduled.Status = types.PaymentStatusOK
+		scheduled.PaymentID = payment.ID
+		payments = append(payments, payment)
+	}
+	return payments, nil
+}
+
+// AddRecurring charges the account every interval, starting one interval from now.
+func (s *Service) AddRecurring(accountID int64, amount types.Money, category types.PaymentCategory, interval time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return "", ErrServiceClosed
+	}
+
+	if amount <= 0 {
+		return "", fmt.Errorf("recurring: amount %d: %w", amount, ErrAmountMustBePositive)
+	}
+
+	if interval <= 0 {
+		return "", fmt.Errorf("recurring: interval %v: %w", interval, ErrInvalidInterval)
+	}
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return "", fmt.Errorf("recurring: %w", err)
+	}
+
+	recurring := &types.RecurringPayment{
+		ID:        s.newID(),
+		AccountID: accountID,
+		Amount:    amount,
+		Category:  category,
+		Interval:  interval,
+		NextRun:   s.currentTime().Add(interval),
+	}
+	s.recurring = append(s.recurring, recurring)
+	return recurring.ID, nil
+}
+
+// TickRecurring fires every elapsed run, a failed charge stays due until the next tick.
+func (s *Service) TickRecurring(now time.Time) {
+	s.mu.Lock()
+	defer s.unlockAndNotify()
+
+	if s.closed {
+		return
+	}
+
+	for _, recurring := range s.recurring {
+		for !recurring.NextRun.After(now) {
+			_, err := s.pay(recurring.AccountID, recurring.Amount, recurring.Category)
+			if err != nil {
+				log.Print(err)
+				break
+			}
+			recurring.NextRun = recurring.NextRun.Add(recurring.Interval)
+		}
+	}
+}
+
+// CanPay returns the error Pay would return without charging anything,
+// category limits are left out because no category is given.
+func (s *Service) CanPay(accountID int64, amount types.Money) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return fmt.Errorf("pay: %w", ErrServiceClosed)
+	}
+	if amount <= 0 {
+		return fmt.Errorf("pay: amount %d: %w", amount, ErrAmountMustBePositive)
+	}
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return fmt.Errorf("pay: %w", err)
+	}
+
+	return s.checkPayable(account, amount, s.fee(amount), "", s.currentTime())
+}
+
+func (s *Service) fee(amount types.Money) types.Money {
+	if s.FeeRate <= 0 {
+		return 0
+	}
+	// The epsilon keeps float noise such as 7.000000000000001 from rounding up.
+	return types.Money(math.Ceil(float64(amount)*s.FeeRate - 1e-9))
+}
+
+func (s *Service) checkPayable(account *types.Account, amount, fee types.Money, category types.PaymentCategory, now time.Time) error {
+	if account.Closed {
+		return fmt.Errorf("pay: account %d: %w", account.ID, ErrAccountClosed)
+	}
+	if account.Frozen {
+		return fmt.Errorf("pay: account %d: %w", account.ID, ErrAccountFrozen)
+	}
+
+	if available(account) < amount+fee {
+		return fmt.Errorf("pay: account %d short by %d: %w", account.ID, amount+fee-available(account), ErrNotEnoughBalance)
+	}
+
+	if limit := s.dailyLimits[account.ID]; limit > 0 && s.spentOn(account.ID, now)+amount > limit {
+		return fmt.Errorf("pay: account %d over daily limit %d: %w", account.ID, limit, ErrDailyLimitExceeded)
+	}
+
+	if limit := s.categoryLimits[category]; limit > 0 && s.spentIn(account.ID, category)+amount > limit {
+		return fmt.Errorf("pay: account %d over %s limit %d: %w", account.ID, category, limit, ErrCategoryLimitExceeded)
+	}
+	return nil
+}
+
+// RetryFailed charges failed payments again in place, the ones that still can not be paid stay failed.
+func (s *Service) RetryFailed(accountID int64) (succeeded int, err error) {
+	s.mu.Lock()
+	defer s.unlockAndNotify()
+
+	if s.closed {
+		return 0, ErrServiceClosed
+	}
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, payment := range s.paymentsByAccount(accountID) {
+		if payment.Status != types.PaymentStatusFail {
+			continue
+		}
+
+		now := s.currentTime()
+		if err := s.checkPayable(account, payment.Amount, payment.Fee, payment.Category, now); err != nil {
+			continue
+		}
+
+		before := account.Balance
+		account.Balance -= payment.Amount + payment.Fee
+		s.noteLowBalance(account, before)
+		payment.Status = types.PaymentStatusInProgress
+		payment.CreatedAt = now
+		s.record(accountID, types.TransactionPayment, -(payment.Amount + payment.Fee), payment.ID)
+		s.audit(OperationPay, accountID, payment.Amount)
+		succeeded++
+	}
+	return succeeded, nil
+}
+
+func (s *Service) SetDailyLimit(accountID int64, limit types.Money) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	if limit <= 0 {
+		delete(s.dailyLimits, accountID)
+		return nil
+	}
+
+	if s.dailyLimits == nil {
+		s.dailyLimits = make(map[int64]types.Money)
+	}
+	s.dailyLimits[accountID] = limit
+	return nil
+}
+
+// SetCategoryLimit caps how much each account may spend in the category in total.
+func (s *Service) SetCategoryLimit(category types.PaymentCategory, limit types.Money) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	if limit <= 0 {
+		delete(s.categoryLimits, category)
+		return nil
+	}
+
+	if s.categoryLimits == nil {
+		s.categoryLimits = make(map[types.PaymentCategory]types.Money)
+	}
+	s.categoryLimits[category] = limit
+	return nil
+}
+
+func (s *Service) spentIn(accountID int64, category types.PaymentCategory) types.Money {
+	spent := types.Money(0)
+	for _, payment := range s.payments {
+		if payment.AccountID != accountID || payment.Status == types.PaymentStatusFail {
+			continue
+		}
+		if payment.Category == category {
+			spent += payment.Amount
+		}
+	}
+	return spent
+}
+
+func (s *Service) spentOn(accountID int64, day time.Time) types.Money {
+	year, month, date := day.Date()
+	spent := types.Money(0)
+	for _, payment := range s.payments {
+		if payment.AccountID != accountID || payment.Status == types.PaymentStatusFail {
+			continue
+		}
+		y, m, d := payment.CreatedAt.Date()
+		if y == year && m == month && d == date {
+			spent += payment.Amount
+		}
+	}
+	return spent
+}
+
+func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findAccountByID(accountID)
+}
+
+func (s *Service) findAccountByID(accountID int64) (*types.Account, error) {
+	account, ok := s.accountsByID[accountID]
+	if !ok {
+		return nil, fmt.Errorf("account %d: %w", accountID, ErrAccountNotFound)
+	}
+	return account, nil
+}
+
+func (s *Service) BalanceOf(accountID int64) (types.Money, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return 0, err
+	}
+	return account.Balance, nil
+}
+
+func (s *Service) FindAccountByPhone(phone types.Phone) (*types.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findAccountByPhone(phone)
+}
+
+// findAccountByPhone prefers the unlabelled account of the phone and falls back to its first sub-account.
+func (s *Service) findAccountByPhone(phone types.Phone) (*types.Account, error) {
+	normalized := normalizePhone(phone)
+	var found *types.Account
+	for _, account := range s.accounts {
+		if account.Phone != normalized && normalizePhone(account.Phone) != normalized {
+			continue
+		}
+		if account.Label == "" {
+			return account, nil
+		}
+		if found == nil {
+			found = account
+		}
+	}
+	if found != nil {
+		return found, nil
+	}
+	return nil, fmt.Errorf("phone %s: %w", phone, ErrAccountNotFound)
+}
+
+func (s *Service) findAccountByLabel(phone types.Phone, label string) (*types.Account, error) {
+	normalized := normalizePhone(phone)
+	for _, account := range s.accounts {
+		if account.Label == label && normalizePhone(account.Phone) == normalized {
+			return account, nil
+		}
+	}
+	return nil, fmt.Errorf("phone %s, label %q: %w", phone, label, ErrAccountNotFound)
+}
+
+// normalizePhone drops everything except digits and a leading plus.
+func normalizePhone(phone types.Phone) types.Phone {
+	var builder strings.Builder
+	for i, r := range string(phone) {
+		if r >= '0' && r <= '9' || r == '+' && i == 0 {
+			builder.WriteRune(r)
+		}
+	}
+	return types.Phone(builder.String())
+}
+
+func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findPaymentByID(paymentID)
+}
+
+func (s *Service) findPaymentByID(paymentID string) (*types.Payment, error) {
+	payment, ok := s.paymentsByID[paymentID]
+	if !ok {
+		return nil, fmt.Errorf("payment %s: %w", paymentID, ErrPaymentNotFound)
+	}
+	return payment, nil
+}
+
+func (s *Service) RecategorizePayment(paymentID string, category types.PaymentCategory) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return err
+	}
+
+	payment.Category = category
+	return nil
+}
+
+func (s *Service) Reject(paymentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	var payment, err = s.findPaymentByID(paymentID)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkRefundable(payment)
+	if err != nil {
+		return err
+	}
+
+	var account, er = s.findAccountByID(payment.AccountID)
+	if er != nil {
+		return er
+	}
+
+	if account.Closed {
+		return fmt.Errorf("account %d: %w", account.ID, ErrAccountClosed)
+	}
+
+	amount := payment.Amount + payment.Fee - s.refunds[payment.ID]
+	if err := s.checkMaxBalance(account, amount); err != nil {
+		return err
+	}
+	payment.Status = types.PaymentStatusFail
+	account.Balance += amount
+	delete(s.refunds, payment.ID)
+	s.record(account.ID, types.TransactionRefund, amount, payment.ID)
+	s.audit(OperationReject, account.ID, amount)
+
+	return nil
+}
+
+func (s *Service) RejectWithRecord(paymentID string) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrServiceClosed
+	}
+
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.checkRefundable(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.findAccountByID(payment.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.Closed {
+		return nil, fmt.Errorf("account %d: %w", account.ID, ErrAccountClosed)
+	}
+
+	amount := payment.Amount - s.refunds[payment.ID]
+	if err := s.checkMaxBalance(account, amount+payment.Fee); err != nil {
+		return nil, err
+	}
+	reversal := &types.Payment{
+		ID:        s.newID(),
+		AccountID: payment.AccountID,
+		Amount:    -amount,
+		Category:  payment.Category,
+		Status:    types.PaymentStatusOK,
+		CreatedAt: s.currentTime(),
+		Fee:       -payment.Fee,
+	}
+
+	account.Balance += amount + payment.Fee
+	delete(s.refunds, payment.ID)
+	if s.reversals == nil {
+		s.reversals = make(map[string]string)
+	}
+	s.reversals[payment.ID] = reversal.ID
+	s.addPayment(reversal)
+	s.record(account.ID, types.TransactionRefund, amount+payment.Fee, payment.ID)
+	s.audit(OperationReject, account.ID, amount+payment.Fee)
+	return reversal, nil
+}
+
+func (s *Service) checkRefundable(payment *types.Payment) error {
+	if payment.Amount < 0 {
+		return ErrReversalPayment
+	}
+	if _, ok := s.reversals[payment.ID]; ok || payment.Status == types.PaymentStatusFail {
+		return ErrPaymentAlreadyRejected
+	}
+	return nil
+}
+
+func (s *Service) ConfirmPayment(paymentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return err
+	}
+
+	if payment.Status == types.PaymentStatusFail {
+		return ErrPaymentAlreadyRejected
+	}
+
+	payment.Status = types.PaymentStatusOK
+	return nil
+}
+
+func (s *Service) PartialRefund(paymentID string, amount types.Money) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	if amount <= 0 {
+		return ErrAmountMustBePositive
+	}
+
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkRefundable(payment)
+	if err != nil {
+		return err
+	}
+
+	refunded := s.refunds[payment.ID]
+	if amount > payment.Amount-refunded {
+		return ErrAmountExceedsPayment
+	}
+
+	account, err := s.findAccountByID(payment.AccountID)
+	if err != nil {
+		return err
+	}
+
+	if account.Closed {
+		return fmt.Errorf("account %d: %w", account.ID, ErrAccountClosed)
+	}
+
+	// The last part of the refund gives the fee back too, like Reject does.
+	full := refunded+amount == payment.Amount
+	credit := amount
+	if full {
+		credit += payment.Fee
+	}
+	if err := s.checkMaxBalance(account, credit); err != nil {
+		return err
+	}
+	account.Balance += credit
+	s.record(account.ID, types.TransactionRefund, credit, payment.ID)
+	s.audit(OperationRefund, account.ID, credit)
+	if full {
+		payment.Status = types.PaymentStatusFail
+		delete(s.refunds, payment.ID)
+		return nil
+	}
+
+	if s.refunds == nil {
+		s.refunds = make(map[string]types.Money)
+	}
+	s.refunds[payment.ID] = refunded + amount
+	return nil
+}
+
+func (s *Service) AddAccountWithBalance(phone types.Phone, balance types.Money) (*types.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, err := s.registerAccount(phone, s.BaseCurrency)
+	if err != nil {
+		return nil, ErrCannotRegisterAccount
+	}
+
+	err = s.deposit(account.ID, balance)
+	if err != nil {
+		return nil, ErrCannotDepositAccount
+	}
+	return account, nil
+}
+
+func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.unlockAndNotify()
+
+	var targetPayment, err = s.findPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	newPayment, err := s.pay(targetPayment.AccountID, targetPayment.Amount, targetPayment.Category)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPayment, nil
+}
+
+func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrServiceClosed
+	}
+
+	payment, err := s.findPaymentByID(paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.favoriteLimitReached(payment.AccountID) {
+		return nil, ErrFavoriteLimitReached
+	}
+
+	favorite := &types.Favorite{
+		ID:        s.newID(),
+		AccountID: payment.AccountID,
+		Name:      name,
+		Amount:    payment.Amount,
+		Category:  payment.Category,
+	}
+	s.favorites = append(s.favorites, favorite)
+	return favorite, nil
+}
+
+func (s *Service) AddFavorite(accountID int64, name string, amount types.Money, category types.PaymentCategory) (*types.Favorite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrServiceClosed
+	}
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+
+	if strings.TrimSpace(name) == "" {
+		return nil, ErrInvalidFavoriteName
+	}
+
+	if s.favoriteLimitReached(accountID) {
+		return nil, ErrFavoriteLimitReached
+	}
+
+	favorite := &types.Favorite{
+		ID:        s.newID(),
+		AccountID: accountID,
+		Name:      name,
+		Amount:    amount,
+		Category:  category,
+	}
+	s.favorites = append(s.favorites, favorite)
+	return favorite, nil
+}
+
+func (s *Service) favoriteLimitReached(accountID int64) bool {
+	return s.MaxFavoritesPerAccount > 0 && len(s.favoritesByAccount(accountID)) >= s.MaxFavoritesPerAccount
+}
+
+func (s *Service) PayFromFavorite(favoriteID string) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.unlockAndNotify()
+
+	favorite, err := s.findFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := s.pay(favorite.AccountID, favorite.Amount, favorite.Category)
+	if err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+// PayFromFavoriteAmount pays the favorite's category from its account with a one-off amount.
+func (s *Service) PayFromFavoriteAmount(favoriteID string, amount types.Money) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.unlockAndNotify()
+
+	favorite, err := s.findFavoriteByID(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.pay(favorite.AccountID, amount, favorite.Category)
+}
+
+// SetFavoriteDestination makes the favorite a transfer to another account, 0 makes it a payment again.
+func (s *Service) SetFavoriteDestination(favoriteID string, destinationID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	favorite, err := s.findFavoriteByID(favoriteID)
+	if err != nil {
+		return err
+	}
+
+	if destinationID != 0 {
+		if destinationID == favorite.AccountID {
+			return fmt.Errorf("favorite %s: %w", favoriteID, ErrSameAccount)
+		}
+		_, err = s.findAccountByID(destinationID)
+		if err != nil {
+			return err
+		}
+	}
+
+	favorite.DestinationID = destinationID
+	return nil
+}
+
+// TransferFavorite moves the favorite amount to its destination, or pays it when there is none.
+func (s *Service) TransferFavorite(favoriteID string) error {
+	s.mu.Lock()
+	defer s.unlockAndNotify()
+
+	favorite, err := s.findFavoriteByID(favoriteID)
+	if err != nil {
+		return err
+	}
+
+	if favorite.DestinationID == 0 {
+		_, err = s.pay(favorite.AccountID, favorite.Amount, favorite.Category)
+		return err
+	}
+	_, err = s.transfer(favorite.AccountID, favorite.DestinationID, favorite.Amount)
+	return err
+}
+
+func (s *Service) FindFavoriteByID(favoriteID string) (*types.Favorite, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findFavoriteByID(favoriteID)
+}
+
+func (s *Service) findFavoriteByID(favoriteID string) (*types.Favorite, error) {
+	for _, favorite := range s.favorites {
+		if favorite.ID == favoriteID {
+			return favorite, nil
+		}
+	}
+	return nil, fmt.Errorf("favorite %s: %w", favoriteID, ErrFavoriteNotFound)
+}
+
+func (s *Service) FavoritesByAccount(accountID int64) ([]*types.Favorite, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.favoritesByAccount(accountID), nil
+}
+
+func (s *Service) favoritesByAccount(accountID int64) []*types.Favorite {
+	favorites := make([]*types.Favorite, 0)
+	for _, favorite := range s.favorites {
+		if favorite.AccountID == accountID {
+			favorites = append(favorites, favorite)
+		}
+	}
+	return favorites
+}
+
+func (s *Service) RemoveFavorite(favoriteID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	for i, favorite := range s.favorites {
+		if favorite.ID == favoriteID {
+			s.favorites = append(s.favorites[:i:i], s.favorites[i+1:]...)
+			return nil
+		}
+	}
+	return ErrFavoriteNotFound
+}
+
+func (s *Service) RenameFavorite(favoriteID string, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	if strings.TrimSpace(newName) == "" {
+		return ErrInvalidFavoriteName
+	}
+
+	favorite, err := s.findFavoriteByID(favoriteID)
+	if err != nil {
+		return err
+	}
+
+	favorite.Name = newName
+	return nil
+}
+
+func (s *Service) UpdateFavoriteAmount(favoriteID string, amount types.Money) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	if amount <= 0 {
+		return ErrAmountMustBePositive
+	}
+
+	favorite, err := s.findFavoriteByID(favoriteID)
+	if err != nil {
+		return err
+	}
+
+	favorite.Amount = amount
+	return nil
+}
+
+func (s *Service) DeleteAccount(accountID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	accounts := make([]*types.Account, 0, len(s.accounts)-1)
+	for _, account := range s.accounts {
+		if account.ID != accountID {
+			accounts = append(accounts, account)
+		}
+	}
+
+	payments := make([]*types.Payment, 0, len(s.payments))
+	for _, payment := range s.payments {
+		if payment.AccountID != accountID {
+			payments = append(payments, payment)
+			continue
+		}
+		delete(s.refunds, payment.ID)
+		delete(s.reversals, payment.ID)
+	}
+
+	// Transfers to the deleted account can not be made any more, so those favorites go too.
+	favorites := make([]*types.Favorite, 0, len(s.favorites))
+	for _, favorite := range s.favorites {
+		if favorite.AccountID != accountID && favorite.DestinationID != accountID {
+			favorites = append(favorites, favorite)
+		}
+	}
+
+	transactions := make([]*types.Transaction, 0, len(s.transactions))
+	for _, transaction := range s.transactions {
+		if transaction.AccountID != accountID {
+			transactions = append(transactions, transaction)
+		}
+	}
+
+	scheduled := make([]*types.ScheduledPayment, 0, len(s.scheduled))
+	for _, payment := range s.scheduled {
+		if payment.AccountID != accountID {
+			scheduled = append(scheduled, payment)
+		}
+	}
+
+	recurring := make([]*types.RecurringPayment, 0, len(s.recurring))
+	for _, payment := range s.recurring {
+		if payment.AccountID != accountID {
+			recurring = append(recurring, payment)
+		}
+	}
+
+	s.accounts = accounts
+	s.payments = payments
+	s.favorites = favorites
+	s.transactions = transactions
+	s.scheduled = scheduled
+	s.recurring = recurring
+	delete(s.dailyLimits, accountID)
+	s.reindex()
+	return nil
+}
+
+// MergeAccounts moves everything of mergeID into keepID and removes mergeID.
+func (s *Service) MergeAccounts(keepID, mergeID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	if keepID == mergeID {
+		return fmt.Errorf("merge: account %d: %w", keepID, ErrSameAccount)
+	}
+
+	keep, err := s.findAccountByID(keepID)
+	if err != nil {
+		return fmt.Errorf("merge: %w", err)
+	}
+
+	merge, err := s.findAccountByID(mergeID)
+	if err != nil {
+		return fmt.Errorf("merge: %w", err)
+	}
+
+	if account := closedAccount(keep, merge); account != nil {
+		return fmt.Errorf("merge: account %d: %w", account.ID, ErrAccountClosed)
+	}
+
+	if keep.Currency != merge.Currency {
+		return fmt.Errorf("merge: %q to %q: %w", merge.Currency, keep.Currency, ErrCurrencyMismatch)
+	}
+
+	if err := s.checkMaxBalance(keep, merge.Balance); err != nil {
+		return fmt.Errorf("merge: %w", err)
+	}
+
+	keep.Balance += merge.Balance
+	for _, payment := range s.payments {
+		if payment.AccountID == mergeID {
+			payment.AccountID = keepID
+		}
+	}
+	// Transfers between the two accounts would become transfers to the account itself, those favorites go.
+	favorites := make([]*types.Favorite, 0, len(s.favorites))
+	for _, favorite := range s.favorites {
+		if favorite.AccountID == mergeID {
+			favorite.AccountID = keepID
+		}
+		if favorite.DestinationID == mergeID {
+			favorite.DestinationID = keepID
+		}
+		if favorite.AccountID != favorite.DestinationID {
+			favorites = append(favorites, favorite)
+		}
+	}
+	s.favorites = favorites
+	for _, transaction := range s.transactions {
+		if transaction.AccountID == mergeID {
+			transaction.AccountID = keepID
+		}
+	}
+	for _, scheduled := range s.scheduled {
+		if scheduled.AccountID == mergeID {
+			scheduled.AccountID = keepID
+		}
+	}
+	for _, recurring := range s.recurring {
+		if recurring.AccountID == mergeID {
+			recurring.AccountID = keepID
+		}
+	}
+
+	accounts := make([]*types.Account, 0, len(s.accounts)-1)
+	for _, account := range s.accounts {
+		if account.ID != mergeID {
+			accounts = append(accounts, account)
+		}
+	}
+	s.accounts = accounts
+	delete(s.dailyLimits, mergeID)
+	s.reindex()
+	return nil
+}
+
+func (s *Service) PaymentsByAccount(accountID int64) ([]*types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.paymentsByAccount(accountID), nil
+}
+
+func (s *Service) paymentsByAccount(accountID int64) []*types.Payment {
+	payments := make([]*types.Payment, 0)
+	for _, payment := range s.payments {
+		if payment.AccountID == accountID {
+			payments = append(payments, payment)
+		}
+	}
+	return payments
+}
+
+func (s *Service) PaymentsByCategory(category types.PaymentCategory) []*types.Payment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	payments := make([]*types.Payment, 0)
+	for _, payment := range s.payments {
+		if payment.Category == category {
+			payments = append(payments, payment)
+		}
+	}
+	return payments
+}
+
+// History returns the payments of the account together with its credits, newest first.
+// Credits are shown as payments with a negative amount, the way reversals are,
+// categorised by their transaction kind.
+func (s *Service) History(accountID int64) ([]*types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := append(s.paymentsByAccount(accountID), s.credits(accountID)...)
+	sortNewestFirst(entries)
+	return entries, nil
+}
+
+func (s *Service) history(accountID int64) []*types.Payment {
+	payments := s.paymentsByAccount(accountID)
+	sortNewestFirst(payments)
+	return payments
+}
+
+func sortNewestFirst(payments []*types.Payment) {
+	sort.SliceStable(payments, func(i, j int) bool {
+		return payments[i].CreatedAt.After(payments[j].CreatedAt)
+	})
+}
+
+// credits turns the incoming transactions of the account into payments,
+// leaving out the refunds of RejectWithRecord that already have a reversal payment.
+func (s *Service) credits(accountID int64) []*types.Payment {
+	reversed := make(map[string]bool)
+	credits := make([]*types.Payment, 0)
+	for _, transaction := range s.transactionsByAccount(accountID) {
+		if transaction.Amount <= 0 {
+			continue
+		}
+		if transaction.Kind == types.TransactionRefund && !reversed[transaction.Reference] {
+			if reversal, err := s.findPaymentByID(s.reversals[transaction.Reference]); err == nil && -(reversal.Amount+reversal.Fee) == transaction.Amount {
+				reversed[transaction.Reference] = true
+				continue
+			}
+		}
+		credits = append(credits, &types.Payment{
+			ID:        "transaction-" + strconv.FormatInt(transaction.ID, 10),
+			AccountID: accountID,
+			Amount:    -transaction.Amount,
+			Category:  types.PaymentCategory(transaction.Kind),
+			Status:    types.PaymentStatusOK,
+			CreatedAt: transaction.CreatedAt,
+		})
+	}
+	return credits
+}
+
+// AccountsRegisteredBetween returns the accounts registered in [from, to).
+func (s *Service) AccountsRegisteredBetween(from, to time.Time) []*types.Account {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := make([]*types.Account, 0)
+	for _, account := range s.accounts {
+		if !account.CreatedAt.Before(from) && account.CreatedAt.Before(to) {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+// PaymentsBetween returns the account payments created in [from, to).
+func (s *Service) PaymentsBetween(accountID int64, from, to time.Time) ([]*types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if from.After(to) {
+		return nil, ErrInvalidRange
+	}
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := make([]*types.Payment, 0)
+	for _, payment := range s.paymentsByAccount(accountID) {
+		if !payment.CreatedAt.Before(from) && payment.CreatedAt.Before(to) {
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
+
+func (s *Service) PaymentsPage(accountID int64, offset, limit int) ([]*types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if offset < 0 || limit < 0 {
+		return nil, ErrInvalidPagination
+	}
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return paginate(s.history(accountID), offset, limit), nil
+}
+
+const defaultPageSize = 50
+
+// AllPayments pages through every payment ordered by CreatedAt, then ID.
+// A negative offset starts from the beginning and a limit below one means defaultPageSize.
+func (s *Service) AllPayments(offset, limit int) []*types.Payment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+
+	payments := make([]*types.Payment, len(s.payments))
+	copy(payments, s.payments)
+	sort.Slice(payments, func(i, j int) bool {
+		if !payments[i].CreatedAt.Equal(payments[j].CreatedAt) {
+			return payments[i].CreatedAt.Before(payments[j].CreatedAt)
+		}
+		return payments[i].ID < payments[j].ID
+	})
+	return paginate(payments, offset, limit)
+}
+
+func paginate(payments []*types.Payment, offset, limit int) []*types.Payment {
+	if offset >= len(payments) {
+		return make([]*types.Payment, 0)
+	}
+
+	end := offset + limit
+	if end > len(payments) {
+		end = len(payments)
+	}
+	return payments[offset:end]
+}
+
+// PaymentFilter selects payments in SearchPayments, zero fields are not filtered on.
+type PaymentFilter struct {
+	AccountID int64
+	MinAmount types.Money
+	MaxAmount types.Money
+	Category  types.PaymentCategory
+	Status    types.PaymentStatus
+	// Description matches payments whose description contains it.
+	Description string
+}
+
+func (f PaymentFilter) match(payment *types.Payment) bool {
+	if f.AccountID != 0 && payment.AccountID != f.AccountID {
+		return false
+	}
+	if f.MinAmount != 0 && payment.Amount < f.MinAmount {
+		return false
+	}
+	if f.MaxAmount != 0 && payment.Amount > f.MaxAmount {
+		return false
+	}
+	if f.Category != "" && payment.Category != f.Category {
+		return false
+	}
+	if f.Status != "" && payment.Status != f.Status {
+		return false
+	}
+	if f.Description != "" && !strings.Contains(payment.Description, f.Description) {
+		return false
+	}
+	return true
+}
+
+func (s *Service) SearchPayments(filter PaymentFilter) []*types.Payment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	payments := make([]*types.Payment, 0)
+	for _, payment := range s.payments {
+		if filter.match(payment) {
+			payments = append(payments, payment)
+		}
+	}
+	return payments
+}
+
+func (s *Service) SpendingByCategory(accountID int64) (map[types.PaymentCategory]types.Money, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	spending := make(map[types.PaymentCategory]types.Money)
+	for _, payment := range s.paymentsByAccount(accountID) {
+		if payment.Status == types.PaymentStatusFail {
+			continue
+		}
+		spending[payment.Category] += payment.Amount
+	}
+	return spending, nil
+}
+
+// MonthlySummary leaves failed payments out.
+func (s *Service) MonthlySummary(accountID int64, year int, month time.Month) (Summary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	inMonth := func(t time.Time) bool {
+		y, m, _ := t.Date()
+		return y == year && m == month
+	}
+
+	summary := Summary{ByCategory: make(map[types.PaymentCategory]types.Money)}
+	for _, payment := range s.paymentsByAccount(accountID) {
+		if payment.Status == types.PaymentStatusFail || !inMonth(payment.CreatedAt) {
+			continue
+		}
+		summary.Spent += payment.Amount
+		summary.Payments++
+		summary.ByCategory[payment.Category] += payment.Amount
+	}
+	for _, transaction := range s.transactionsByAccount(accountID) {
+		if transaction.Kind == types.TransactionDeposit && inMonth(transaction.CreatedAt) {
+			summary.Deposited += transaction.Amount
+		}
+	}
+	return summary, nil
+}
+
+// AveragePayment skips failed payments as well as reversed ones and their reversals.
+func (s *Service) AveragePayment(accountID int64) (types.Money, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return 0, err
+	}
+
+	amounts := s.paidAmounts(accountID)
+	if len(amounts) == 0 {
+		return 0, nil
+	}
+	total := types.Money(0)
+	for _, amount := range amounts {
+		total += amount
+	}
+	return total / types.Money(len(amounts)), nil
+}
+
+// AmountPercentile uses the nearest rank, so the result is always one of the paid amounts.
+func (s *Service) AmountPercentile(accountID int64, p float64) (types.Money, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !(p >= 0 && p <= 100) {
+		return 0, fmt.Errorf("percentile %v: %w", p, ErrInvalidPercentile)
+	}
+
+	_, err := s.findAccountByID(accountID)
+	if err != nil {
+		return 0, err
+	}
+
+	amounts := s.paidAmounts(accountID)
+	if len(amounts) == 0 {
+		return 0, nil
+	}
+	sort.Slice(amounts, func(i, j int) bool {
+		return amounts[i] < amounts[j]
+	})
+	rank := int(math.Ceil(p / 100 * float64(len(amounts))))
+	if rank < 1 {
+		rank = 1
+	}
+	return amounts[rank-1], nil
+}
+
+// paidAmounts leaves out failed payments, reversals and the payments they reversed.
+func (s *Service) paidAmounts(accountID int64) []types.Money {
+	amounts := make([]types.Money, 0)
+	for _, payment := range s.paymentsByAccount(accountID) {
+		if payment.Status == types.PaymentStatusFail || payment.Amount < 0 {
+			continue
+		}
+		if _, ok := s.reversals[payment.ID]; ok {
+			continue
+		}
+		amounts = append(amounts, payment.Amount)
+	}
+	return amounts
+}
+
+// TopSpender breaks ties in favor of the lowest account ID.
+func (s *Service) TopSpender() (*types.Account, types.Money, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.accounts) == 0 {
+		return nil, 0, ErrNoAccounts
+	}
+
+	spent := make(map[int64]types.Money, len(s.accounts))
+	for _, payment := range s.payments {
+		if payment.Status == types.PaymentStatusFail {
+			continue
+		}
+		spent[payment.AccountID] += payment.Amount
+	}
+
+	var top *types.Account
+	for _, account := range s.accounts {
+		if top == nil || spent[account.ID] > spent[top.ID] ||
+			spent[account.ID] == spent[top.ID] && account.ID < top.ID {
+			top = account
+		}
+	}
+	return top, spent[top.ID], nil
+}
+
+// TopCategories ranks categories by spending across all accounts, ties go alphabetically.
+func (s *Service) TopCategories(n int) []CategorySpend {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	totals := make(map[types.PaymentCategory]types.Money)
+	for _, payment := range s.payments {
+		if payment.Status == types.PaymentStatusFail {
+			continue
+		}
+		totals[payment.Category] += payment.Amount
+	}
+
+	spends := make([]CategorySpend, 0, len(totals))
+	for category, total := range totals {
+		spends = append(spends, CategorySpend{Category: category, Total: total})
+	}
+	sort.Slice(spends, func(i, j int) bool {
+		if spends[i].Total != spends[j].Total {
+			return spends[i].Total > spends[j].Total
+		}
+		return spends[i].Category < spends[j].Category
+	})
+
+	if n < 0 {
+		n = 0
+	}
+	if n < len(spends) {
+		spends = spends[:n]
+	}
+	return spends
+}
+
+func (s *Service) AccountsBelow(threshold types.Money) []*types.Account {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := make([]*types.Account, 0)
+	for _, account := range s.accounts {
+		if account.Balance < threshold {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+func (s *Service) TotalBalance() types.Money {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	total := types.Money(0)
+	for _, account := range s.accounts {
+		total += account.Balance
+	}
+	return total
+}
+
+func (s *Service) SumPayments(goroutines int) types.Money {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if goroutines < 1 {
+		goroutines = 1
+	}
+
+	size := (len(s.payments) + goroutines - 1) / goroutines
+	if goroutines == 1 || size == 0 {
+		return sumPayments(s.payments)
+	}
+
+	wg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+	total := types.Money(0)
+	for start := 0; start < len(s.payments); start += size {
+		end := start + size
+		if end > len(s.payments) {
+			end = len(s.payments)
+		}
+		wg.Add(1)
+		go func(payments []*types.Payment) {
+			defer wg.Done()
+			sum := sumPayments(payments)
+			mu.Lock()
+			defer mu.Unlock()
+			total += sum
+		}(s.payments[start:end])
+	}
+	wg.Wait()
+	return total
+}
+
+func (s *Service) CountByStatus() map[types.PaymentStatus]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[types.PaymentStatus]int)
+	for _, payment := range s.payments {
+		counts[payment.Status]++
+	}
+	return counts
+}
+
+func sumPayments(payments []*types.Payment) types.Money {
+	sum := types.Money(0)
+	for _, payment := range payments {
+		sum += payment.Amount
+	}
+	return sum
+}
+
+// Accounts returns a copy of the accounts slice, mutating the accounts themselves is discouraged.
+func (s *Service) Accounts() []*types.Account {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := make([]*types.Account, len(s.accounts))
+	copy(accounts, s.accounts)
+	return accounts
+}
+
+func (s *Service) AccountViews() []AccountView {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	views := make([]AccountView, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		views = append(views, AccountView{
+			ID:      account.ID,
+			Phone:   account.Phone,
+			Balance: account.Balance,
+		})
+	}
+	return views
+}
+
+// Payments returns a copy of the payments slice, mutating the payments themselves is discouraged.
+func (s *Service) Payments() []*types.Payment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	payments := make([]*types.Payment, len(s.payments))
+	copy(payments, s.payments)
+	return payments
+}
+
+// Favorites returns a copy of the favorites slice, mutating the favorites themselves is discouraged.
+func (s *Service) Favorites() []*types.Favorite {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	favorites := make([]*types.Favorite, len(s.favorites))
+	copy(favorites, s.favorites)
+	return favorites
+}
+
+// Clone returns a deep copy, changes to the clone never reach the original.
+func (s *Service) Clone() *Service {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	clone := &Service{
+		nextAccountID:           s.nextAccountID,
+		nextTxID:                s.nextTxID,
+		accounts:                make([]*types.Account, 0, len(s.accounts)),
+		payments:                make([]*types.Payment, 0, len(s.payments)),
+		favorites:               make([]*types.Favorite, 0, len(s.favorites)),
+		scheduled:               make([]*types.ScheduledPayment, 0, len(s.scheduled)),
+		recurring:               make([]*types.RecurringPayment, 0, len(s.recurring)),
+		transactions:            make([]*types.Transaction, 0, len(s.transactions)),
+		auditLog:                make([]AuditEntry, len(s.auditLog)),
+		lowBalance:              append([]lowBalanceHook(nil), s.lowBalance...),
+		now:                     s.now,
+		idFunc:                  s.idFunc,
+		MaxBalance:              s.MaxBalance,
+		BaseCurrency:            s.BaseCurrency,
+		MaxAccounts:             s.MaxAccounts,
+		BlockDepositsWhenFrozen: s.BlockDepositsWhenFrozen,
+		FeeRate:                 s.FeeRate,
+		MaxFavoritesPerAccount:  s.MaxFavoritesPerAccount,
+		MinDeposit:              s.MinDeposit,
+	}
+	for _, account := range s.accounts {
+		account := *account
+		if account.Tags != nil {
+			account.Tags = append([]string(nil), account.Tags...)
+		}
+		clone.accounts = append(clone.accounts, &account)
+	}
+	for _, payment := range s.payments {
+		payment := *payment
+		clone.payments = append(clone.payments, &payment)
+	}
+	for _, favorite := range s.favorites {
+		favorite := *favorite
+		clone.favorites = append(clone.favorites, &favorite)
+	}
+	for _, scheduled := range s.scheduled {
+		scheduled := *scheduled
+		clone.scheduled = append(clone.scheduled, &scheduled)
+	}
+	for _, recurring := range s.recurring {
+		recurring := *recurring
+		clone.recurring = append(clone.recurring, &recurring)
+	}
+	for _, transaction := range s.transactions {
+		transaction := *transaction
+		clone.transactions = append(clone.transactions, &transaction)
+	}
+	copy(clone.auditLog, s.auditLog)
+
+	if s.refunds != nil {
+		clone.refunds = make(map[string]types.Money, len(s.refunds))
+		for ID, amount := range s.refunds {
+			clone.refunds[ID] = amount
+		}
+	}
+	if s.reversals != nil {
+		clone.reversals = make(map[string]string, len(s.reversals))
+		for ID, reversalID := range s.reversals {
+			clone.reversals[ID] = reversalID
+		}
+	}
+	if s.dailyLimits != nil {
+		clone.dailyLimits = make(map[int64]types.Money, len(s.dailyLimits))
+		for accountID, limit := range s.dailyLimits {
+			clone.dailyLimits[accountID] = limit
+		}
+	}
+	if s.categoryLimits != nil {
+		clone.categoryLimits = make(map[types.PaymentCategory]types.Money, len(s.categoryLimits))
+		for category, limit := range s.categoryLimits {
+			clone.categoryLimits[category] = limit
+		}
+	}
+	if s.depositKeys != nil {
+		clone.depositKeys = make(map[string]bool, len(s.depositKeys))
+		for key := range s.depositKeys {
+			clone.depositKeys[key] = true
+		}
+	}
+	clone.reindex()
+	return clone
+}
+
+// Reset drops all data but keeps the configuration, clock and ID generator.
+func (s *Service) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = false
+	s.nextAccountID = 0
+	s.accounts = nil
+	s.accountsByID = nil
+	s.payments = nil
+	s.paymentsByID = nil
+	s.refunds = nil
+	s.reversals = nil
+	s.dailyLimits = nil
+	s.categoryLimits = nil
+	s.depositKeys = nil
+	s.favorites = nil
+	s.scheduled = nil
+	s.recurring = nil
+	s.nextTxID = 0
+	s.transactions = nil
+	s.auditLog = nil
+}
+
+// Validate checks the service invariants and reports every violation found.
+func (s *Service) Validate() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	violations := make([]string, 0)
+	IDs := make(map[int64]bool, len(s.accounts))
+	phones := make(map[string]bool, len(s.accounts))
+	for _, account := range s.accounts {
+		if IDs[account.ID] {
+			violations = append(violations, fmt.Sprintf("account %d: duplicate id", account.ID))
+		}
+		IDs[account.ID] = true
+		key := string(account.Phone) + ";" + account.Label
+		if phones[key] {
+			violations = append(violations, fmt.Sprintf("account %d: duplicate phone %s", account.ID, account.Phone))
+		}
+		phones[key] = true
+		if available(account) < 0 {
+			violations = append(violations, fmt.Sprintf("account %d: balance %d below overdraft limit %d", account.ID, account.Balance, account.OverdraftLimit))
+		}
+		if account.ID > s.nextAccountID {
+			violations = append(violations, fmt.Sprintf("account %d: id not below next account id %d", account.ID, s.nextAccountID+1))
+		}
+	}
+
+	for _, payment := range s.payments {
+		if !IDs[payment.AccountID] {
+			violations = append(violations, fmt.Sprintf("payment %s: unknown account %d", payment.ID, payment.AccountID))
+		}
+	}
+	for _, favorite := range s.favorites {
+		if !IDs[favorite.AccountID] {
+			violations = append(violations, fmt.Sprintf("favorite %s: unknown account %d", favorite.ID, favorite.AccountID))
+		}
+		if favorite.DestinationID != 0 && !IDs[favorite.DestinationID] {
+			violations = append(violations, fmt.Sprintf("favorite %s: unknown destination account %d", favorite.ID, favorite.DestinationID))
+		}
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%w: %s", ErrInconsistentState, strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+func (s *Service) getAccounts() []*types.Account {
+	return s.accounts
+}
+
+func (s *Service) ExportToFile(path string) error {
+	return s.ExportToFileContext(context.Background(), path)
+}
+
+func (s *Service) ExportToFileContext(ctx context.Context, path string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.exportToFile(ctx, path)
+}
+
+// Close writes the final state to path, after which every method changing the state fails with ErrServiceClosed
+// until Reset.
+func (s *Service) Close(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+	err := s.exportToFile(context.Background(), path)
+	if err != nil {
+		return err
+	}
+	s.closed = true
+	return nil
+}
+
+func (s *Service) exportToFile(ctx context.Context, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	err = s.exportRecords(ctx, writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); closeErr != nil {
+		log.Print(closeErr)
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		if removeErr := os.Remove(path); removeErr != nil {
+			log.Print(removeErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// ExportTo writes the same records as ExportToFile to w.
+func (s *Service) ExportTo(w io.Writer) error {
+	return s.ExportToContext(context.Background(), w)
+}
+
+func (s *Service) ExportToContext(ctx context.Context, w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.exportRecords(ctx, w)
+}
+
+func (s *Service) exportRecords(ctx context.Context, w io.Writer) error {
+	_, err := w.Write([]byte(exportVersion + "\n"))
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+
+	for _, account := range s.getAccounts() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ID := strconv.FormatInt(account.ID, 10) + ";"
+		phone := string(account.Phone) + ";"
+		balance := strconv.FormatInt(int64(account.Balance), 10) + ";"
+		currency := string(account.Currency) + ";"
+		frozen := strconv.FormatBool(account.Frozen) + ";"
+		overdraft := strconv.FormatInt(int64(account.OverdraftLimit), 10) + ";"
+		tags := strings.Join(account.Tags, ",") + ";"
+		label := escapeField(account.Label) + ";"
+		createdAt := account.CreatedAt.Format(time.RFC3339Nano) + ";"
+		closed := strconv.FormatBool(account.Closed)
+		_, err := w.Write([]byte(ID + phone + balance + currency + frozen + overdraft + tags + label + createdAt + closed + "|"))
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+
+	for _, payment := range s.payments {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ID := payment.ID + ";"
+		AccountID := strconv.FormatInt(payment.AccountID, 10) + ";"
+		Amount := strconv.FormatInt(int64(payment.Amount), 10) + ";"
+		Category := string(payment.Category) + ";"
+		Status := string(payment.Status) + ";"
+		CreatedAt := payment.CreatedAt.Format(time.RFC3339Nano) + ";"
+		Description := escapeField(payment.Description) + ";"
+		Fee := strconv.FormatInt(int64(payment.Fee), 10)
+		_, err := w.Write([]byte(paymentRecord + ";" + ID + AccountID + Amount + Category + Status + CreatedAt + Description + Fee + "|"))
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+
+	for _, favorite := range s.favorites {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ID := favorite.ID + ";"
+		AccountID := strconv.FormatInt(favorite.AccountID, 10) + ";"
+		Name := escapeField(favorite.Name) + ";"
+		Amount := strconv.FormatInt(int64(favorite.Amount), 10) + ";"
+		Category := string(favorite.Category) + ";"
+		DestinationID := strconv.FormatInt(favorite.DestinationID, 10)
+		_, err := w.Write([]byte(favoriteRecord + ";" + ID + AccountID + Name + Amount + Category + DestinationID + "|"))
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+
+	for _, transaction := range s.transactions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ID := strconv.FormatInt(transaction.ID, 10) + ";"
+		AccountID := strconv.FormatInt(transaction.AccountID, 10) + ";"
+		Kind := string(transaction.Kind) + ";"
+		Amount := strconv.FormatInt(int64(transaction.Amount), 10) + ";"
+		Reference := escapeField(transaction.Reference) + ";"
+		CreatedAt := transaction.CreatedAt.Format(time.RFC3339Nano)
+		_, err := w.Write([]byte(transactionRecord + ";" + ID + AccountID + Kind + Amount + Reference + CreatedAt + "|"))
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+
+	refunded := make([]string, 0, len(s.refunds))
+	for paymentID := range s.refunds {
+		refunded = append(refunded, paymentID)
+	}
+	sort.Strings(refunded)
+	for _, paymentID := range refunded {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		amount := strconv.FormatInt(int64(s.refunds[paymentID]), 10)
+		_, err := w.Write([]byte(refundRecord + ";" + paymentID + ";" + amount + "|"))
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+
+	for _, scheduled := range s.scheduled {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ID := scheduled.ID + ";"
+		AccountID := strconv.FormatInt(scheduled.AccountID, 10) + ";"
+		Amount := strconv.FormatInt(int64(scheduled.Amount), 10) + ";"
+		Category := string(scheduled.Category) + ";"
+		At := scheduled.At.Format(time.RFC3339Nano) + ";"
+		Status := string(scheduled.Status) + ";"
+		PaymentID := scheduled.PaymentID
+		_, err := w.Write([]byte(scheduledRecord + ";" + ID + AccountID + Amount + Category + At + Status + PaymentID + "|"))
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+
+	for _, recurring := range s.recurring {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ID := recurring.ID + ";"
+		AccountID := strconv.FormatInt(recurring.AccountID, 10) + ";"
+		Amount := strconv.FormatInt(int64(recurring.Amount), 10) + ";"
+		Category := string(recurring.Category) + ";"
+		Interval := strconv.FormatInt(int64(recurring.Interval), 10) + ";"
+		NextRun := recurring.NextRun.Format(time.RFC3339Nano)
+		_, err := w.Write([]byte(recurringRecord + ";" + ID + AccountID + Amount + Category + Interval + NextRun + "|"))
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+
+	limited := make([]int64, 0, len(s.dailyLimits))
+	for accountID := range s.dailyLimits {
+		limited = append(limited, accountID)
+	}
+	sort.Slice(limited, func(i, j int) bool {
+		return limited[i] < limited[j]
+	})
+	for _, accountID := range limited {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ID := strconv.FormatInt(accountID, 10) + ";"
+		limit := strconv.FormatInt(int64(s.dailyLimits[accountID]), 10)
+		_, err := w.Write([]byte(dailyLimitRecord + ";" + ID + limit + "|"))
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+
+	categories := make([]string, 0, len(s.categoryLimits))
+	for category := range s.categoryLimits {
+		categories = append(categories, string(category))
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		limit := strconv.FormatInt(int64(s.categoryLimits[types.PaymentCategory(category)]), 10)
+		_, err := w.Write([]byte(categoryLimitRecord + ";" + category + ";" + limit + "|"))
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+
+	reversed := make([]string, 0, len(s.reversals))
+	for paymentID := range s.reversals {
+		reversed = append(reversed, paymentID)
+	}
+	sort.Strings(reversed)
+	for _, paymentID := range reversed {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		_, err := w.Write([]byte(reversalRecord + ";" + paymentID + ";" + s.reversals[paymentID] + "|"))
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(s.depositKeys))
+	for key := range s.depositKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		_, err := w.Write([]byte(depositKeyRecord + ";" + key + "|"))
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+
+	nextAccountID := strconv.FormatInt(s.nextAccountID, 10)
+	_, err = w.Write([]byte(nextAccountIDRecord + ";" + nextAccountID + "|"))
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+func (s *Service) ExportToFileGzip(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			log.Print(closeErr)
+		}
+	}()
+
+	writer := gzip.NewWriter(file)
+	err = s.ExportTo(writer)
+	if err != nil {
+		return err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+func (s *Service) ImportFromFileGzip(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			log.Print(closeErr)
+		}
+	}()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer func() {
+		if closeErr := reader.Close(); closeErr != nil {
+			log.Print(closeErr)
+		}
+	}()
+
+	return s.ImportFrom(reader)
+}
+
+func (s *Service) ImportFromFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			log.Print(closeErr)
+		}
+	}()
+
+	return s.ImportFrom(file)
+}
+
+// ImportFrom reads records written by ExportTo or ExportToFile from r.
+func (s *Service) ImportFrom(r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	return s.importRecords(r)
+}
+
+func (s *Service) RestoreFromFile(path string) error {
+	restored := &Service{}
+	err := restored.ImportFromFile(path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	s.restore(restored.snapshot())
+	return nil
+}
+
+func (s *Service) MergeFromFile(path string) (merged int, skipped int, err error) {
+	incoming := &Service{}
+	err = incoming.ImportFromFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, 0, ErrServiceClosed
+	}
+
+	IDs := make(map[int64]int64, len(incoming.accounts))
+	existing := make(map[int64]int64)
+	for _, account := range incoming.accounts {
+		if acc, err := s.findAccountByLabel(account.Phone, account.Label); err == nil {
+			existing[account.ID] = acc.ID
+			skipped++
+			continue
+		}
+
+		s.nextAccountID++
+		IDs[account.ID] = s.nextAccountID
+		account.ID = s.nextAccountID
+		s.addAccount(account)
+		merged++
+	}
+
+	for _, payment := range incoming.payments {
+		ID, ok := IDs[payment.AccountID]
+		if !ok {
+			continue
+		}
+		if _, err := s.findPaymentByID(payment.ID); err == nil {
+			continue
+		}
+		payment.AccountID = ID
+		s.addPayment(payment)
+		if reversalID, ok := incoming.reversals[payment.ID]; ok {
+			if s.reversals == nil {
+				s.reversals = make(map[string]string)
+			}
+			s.reversals[payment.ID] = reversalID
+		}
+		if amount, ok := incoming.refunds[payment.ID]; ok {
+			if s.refunds == nil {
+				s.refunds = make(map[string]types.Money)
+			}
+			s.refunds[payment.ID] = amount
+		}
+	}
+
+	for _, transaction := range incoming.transactions {
+		ID, ok := IDs[transaction.AccountID]
+		if !ok {
+			continue
+		}
+		s.nextTxID++
+		transaction.ID = s.nextTxID
+		transaction.AccountID = ID
+		s.transactions = append(s.transactions, transaction)
+	}
+
+	for _, favorite := range incoming.favorites {
+		ID, ok := IDs[favorite.AccountID]
+		if !ok {
+			continue
+		}
+		if _, err := s.findFavoriteByID(favorite.ID); err == nil {
+			continue
+		}
+		favorite.AccountID = ID
+		if destinationID, ok := IDs[favorite.DestinationID]; ok {
+			favorite.DestinationID = destinationID
+		} else if destinationID, ok := existing[favorite.DestinationID]; ok {
+			favorite.DestinationID = destinationID
+		}
+		s.favorites = append(s.favorites, favorite)
+	}
+	return merged, skipped, nil
+}
+
+func (s *Service) importRecords(r io.Reader) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+
+	version, str := "", strings.TrimSpace(string(content))
+	if strings.HasPrefix(str, "v") {
+		version = str
+		if end := strings.IndexByte(str, '\n'); end >= 0 {
+			version, str = str[:end], str[end+1:]
+		} else {
+			str = ""
+		}
+		version = strings.TrimSpace(version)
+	}
+
+	switch version {
+	case "", exportVersion:
+		return s.importRecordsV1(str)
+	default:
+		err := fmt.Errorf("%w: %q", ErrUnsupportedVersion, version)
+		log.Print(err)
+		return err
+	}
+}
+
+// accountLabelField and freeTextFields give the position of the one free text field of each record.
+const accountLabelField = 7
+
+var freeTextFields = map[string]int{
+	paymentRecord:     7,
+	favoriteRecord:    3,
+	transactionRecord: 5,
+}
+
+// importRecordsV1 also reads files written before exports had a version line.
+func (s *Service) importRecordsV1(str string) error {
+	accounts := make([]*types.Account, 0)
+	payments := make([]*types.Payment, 0)
+	favorites := make([]*types.Favorite, 0)
+	depositKeys := make([]string, 0)
+	transactions := make([]*types.Transaction, 0)
+	reversals := make(map[string]string)
+	refunds := make(map[string]types.Money)
+	scheduled := make([]*types.ScheduledPayment, 0)
+	recurring := make([]*types.RecurringPayment, 0)
+	dailyLimits := make(map[int64]types.Money)
+	categoryLimits := make(map[types.PaymentCategory]types.Money)
+	nextAccountID := s.nextAccountID
+	nextTxID := s.nextTxID
+	for i, line := range strings.Split(str, "|") {
+		if len(strings.TrimSpace(line)) <= 0 {
+			continue
+		}
+
+		// Files edited on other systems pick up CRs and stray spaces around separators,
+		// free text keeps its own spaces since it is escaped on export.
+		item := strings.Split(line, ";")
+		item[0] = strings.TrimSpace(item[0])
+		text, ok := freeTextFields[item[0]]
+		if !ok {
+			text = accountLabelField
+		}
+		for j := range item {
+			if j != text {
+				item[j] = strings.TrimSpace(item[j])
+			}
+		}
+		switch item[0] {
+		case paymentRecord:
+			payment, err := parsePaymentRecord(i+1, item)
+			if err != nil {
+				log.Print(err)
+				return err
+			}
+			payments = append(payments, payment)
+		case favoriteRecord:
+			favorite, err := parseFavoriteRecord(i+1, item)
+			if err != nil {
+				log.Print(err)
+				return err
+			}
+			favorites = append(favorites, favorite)
+		case transactionRecord:
+			transaction, err := parseTransactionRecord(i+1, item)
+			if err != nil {
+				log.Print(err)
+				return err
+			}
+			if transaction.ID > nextTxID {
+				nextTxID = transaction.ID
+			}
+			transactions = append(transactions, transaction)
+		case refundRecord:
+			if len(item) != 3 || item[1] == "" {
+				err := fmt.Errorf("%w: record %d: invalid refund record", ErrCorruptedExport, i+1)
+				log.Print(err)
+				return err
+			}
+			amount, err := strconv.ParseInt(item[2], 10, 64)
+			if err != nil || amount <= 0 {
+				err := fmt.Errorf("%w: record %d: invalid refund amount %q", ErrCorruptedExport, i+1, item[2])
+				log.Print(err)
+				return err
+			}
+			refunds[item[1]] = types.Money(amount)
+		case scheduledRecord:
+			payment, err := parseScheduledRecord(i+1, item)
+			if err != nil {
+				log.Print(err)
+				return err
+			}
+			scheduled = append(scheduled, payment)
+		case recurringRecord:
+			payment, err := parseRecurringRecord(i+1, item)
+			if err != nil {
+				log.Print(err)
+				return err
+			}
+			recurring = append(recurring, payment)
+		case dailyLimitRecord:
+			if len(item) != 3 {
+				err := fmt.Errorf("%w: record %d: invalid daily limit record", ErrCorruptedExport, i+1)
+				log.Print(err)
+				return err
+			}
+			accountID, err := strconv.ParseInt(item[1], 10, 64)
+			if err != nil {
+				err := fmt.Errorf("%w: record %d: invalid account id %q", ErrCorruptedExport, i+1, item[1])
+				log.Print(err)
+				return err
+			}
+			limit, err := strconv.ParseInt(item[2], 10, 64)
+			if err != nil || limit <= 0 {
+				err := fmt.Errorf("%w: record %d: invalid daily limit %q", ErrCorruptedExport, i+1, item[2])
+				log.Print(err)
+				return err
+			}
+			dailyLimits[accountID] = types.Money(limit)
+		case categoryLimitRecord:
+			if len(item) != 3 {
+				err := fmt.Errorf("%w: record %d: invalid category limit record", ErrCorruptedExport, i+1)
+				log.Print(err)
+				return err
+			}
+			limit, err := strconv.ParseInt(item[2], 10, 64)
+			if err != nil || limit <= 0 {
+				err := fmt.Errorf("%w: record %d: invalid category limit %q", ErrCorruptedExport, i+1, item[2])
+				log.Print(err)
+				return err
+			}
+			categoryLimits[types.PaymentCategory(item[1])] = types.Money(limit)
+		case reversalRecord:
+			if len(item) != 3 || item[1] == "" || item[2] == "" {
+				err := fmt.Errorf("%w: record %d: invalid reversal record", ErrCorruptedExport, i+1)
+				log.Print(err)
+				return err
+			}
+			reversals[item[1]] = item[2]
+		case depositKeyRecord:
+			if len(item) != 2 || item[1] == "" {
+				err := fmt.Errorf("%w: record %d: invalid deposit key record", ErrCorruptedExport, i+1)
+				log.Print(err)
+				return err
+			}
+			depositKeys = append(depositKeys, item[1])
+		case nextAccountIDRecord:
+			ID, err := parseNextAccountIDRecord(i+1, item)
+			if err != nil {
+				log.Print(err)
+				return err
+			}
+			if ID > nextAccountID {
+				nextAccountID = ID
+			}
+		default:
+			account, err := parseAccountRecord(i+1, item)
+			if err != nil {
+				log.Print(err)
+				return err
+			}
+			if account.ID > nextAccountID {
+				nextAccountID = account.ID
+			}
+			accounts = append(accounts, account)
+		}
+	}
+
+	for _, account := range accounts {
+		s.addAccount(account)
+	}
+	for _, payment := range payments {
+		s.addPayment(payment)
+	}
+	s.favorites = append(s.favorites, favorites...)
+	s.transactions = append(s.transactions, transactions...)
+	s.scheduled = append(s.scheduled, scheduled...)
+	s.recurring = append(s.recurring, recurring...)
+	s.nextTxID = nextTxID
+	if len(dailyLimits) > 0 && s.dailyLimits == nil {
+		s.dailyLimits = make(map[int64]types.Money, len(dailyLimits))
+	}
+	for accountID, limit := range dailyLimits {
+		s.dailyLimits[accountID] = limit
+	}
+	if len(categoryLimits) > 0 && s.categoryLimits == nil {
+		s.categoryLimits = make(map[types.PaymentCategory]types.Money, len(categoryLimits))
+	}
+	for category, limit := range categoryLimits {
+		s.categoryLimits[category] = limit
+	}
+	if len(reversals) > 0 && s.reversals == nil {
+		s.reversals = make(map[string]string, len(reversals))
+	}
+	for paymentID, reversalID := range reversals {
+		s.reversals[paymentID] = reversalID
+	}
+	if len(refunds) > 0 && s.refunds == nil {
+		s.refunds = make(map[string]types.Money, len(refunds))
+	}
+	for paymentID, amount := range refunds {
+		s.refunds[paymentID] = amount
+	}
+	if len(depositKeys) > 0 && s.depositKeys == nil {
+		s.depositKeys = make(map[string]bool, len(depositKeys))
+	}
+	for _, key := range depositKeys {
+		s.depositKeys[key] = true
+	}
+	s.nextAccountID = nextAccountID
+	return nil
+}
+
+const exportVersion = "v1"
+
+const (
+	paymentRecord       = "payment"
+	favoriteRecord      = "favorite"
+	transactionRecord   = "transaction"
+	reversalRecord      = "reversal"
+	scheduledRecord     = "scheduled"
+	recurringRecord     = "recurring"
+	dailyLimitRecord    = "daily"
+	categoryLimitRecord = "category"
+	refundRecord        = "refund"
+	depositKeyRecord    = "key"
+	nextAccountIDRecord = "next"
+)
+
+func parseAccountRecord(number int, item []string) (*types.Account, error) {
+	if len(item) < 3 || len(item) > 10 {
+		return nil, fmt.Errorf("%w: record %d: expected 3 to 10 fields, got %d", ErrCorruptedExport, number, len(item))
+	}
+
+	ID, err := strconv.ParseInt(item[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: record %d: invalid id %q", ErrCorruptedExport, number, item[0])
+	}
+
+	balance, err := strconv.ParseInt(item[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: record %d: invalid balance %q", ErrCorruptedExport, number, item[2])
+	}
+	account := &types.Account{
+		ID:      ID,
+		Phone:   types.Phone(item[1]),
+		Balance: types.Money(balance),
+	}
+	if len(item) > 3 {
+		account.Currency = types.Currency(item[3])
+	}
+	if len(item) > 4 {
+		account.Frozen, err = strconv.ParseBool(item[4])
+		if err != nil {
+			return nil, fmt.Errorf("%w: record %d: invalid frozen flag %q", ErrCorruptedExport, number, item[4])
+		}
+	}
+	if len(item) > 5 {
+		overdraft, err := strconv.ParseInt(item[5], 10, 64)
+		if err != nil || overdraft < 0 {
+			return nil, fmt.Errorf("%w: record %d: invalid overdraft limit %q", ErrCorruptedExport, number, item[5])
+		}
+		account.OverdraftLimit = types.Money(overdraft)
+	}
+	if len(item) > 6 {
+		account.Tags = parseTags(item[6])
+	}
+	if len(item) > 7 {
+		account.Label = unescapeField(item[7])
+	}
+	if len(item) > 8 {
+		account.CreatedAt, err = parseTime(item[8])
+		if err != nil {
+			return nil, fmt.Errorf("%w: record %d: invalid created at %q", ErrCorruptedExport, number, item[8])
+		}
+	}
+	if len(item) > 9 {
+		account.Closed, err = strconv.ParseBool(item[9])
+		if err != nil {
+			return nil, fmt.Errorf("%w: record %d: invalid closed flag %q", ErrCorruptedExport, number, item[9])
+		}
+	}
+	if account.Balance < -account.OverdraftLimit {
+		return nil, fmt.Errorf("%w: record %d: balance %d below overdraft limit %d", ErrCorruptedExport, number, balance, account.OverdraftLimit)
+	}
+	return account, nil
+}
+
+func parsePaymentRecord(number int, item []string) (*types.Payment, error) {
+	if len(item) < 6 || len(item) > 9 {
+		return nil, fmt.Errorf("%w: record %d: expected 6 to 9 fields, got %d", ErrCorruptedExport, number, len(item))
+	}
+
+	accountID, err := strconv.ParseInt(item[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: record %d: invalid account id %q", ErrCorruptedExport, number, item[2])
+	}
+
+	amount, err := strconv.ParseInt(item[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: record %d: invalid amount %q", ErrCorruptedExport, number, item[3])
+	}
+
+	createdAt := time.Time{}
+	if len(item) > 6 {
+		createdAt, err = parseTime(item[6])
+		if err != nil {
+			return nil, fmt.Errorf("%w: record %d: invalid created at %q", ErrCorruptedExport, number, item[6])
+		}
+	}
+
+	description := ""
+	if len(item) > 7 {
+		description = unescapeField(item[7])
+	}
+
+	fee := int64(0)
+	if len(item) > 8 {
+		fee, err = strconv.ParseInt(item[8], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: record %d: invalid fee %q", ErrCorruptedExport, number, item[8])
+		}
+	}
+
+	return &types.Payment{
+		ID:          item[1],
+		AccountID:   accountID,
+		Amount:      types.Money(amount),
+		Category:    types.PaymentCategory(item[4]),
+		Status:      types.PaymentStatus(item[5]),
+		CreatedAt:   createdAt,
+		Description: description,
+		Fee:         types.Money(fee),
+	}, nil
+}
+
+var fieldEscaper = strings.NewReplacer("%", "%25", ";", "%3B", "|", "%7C", "\n", "%0A")
+var fieldUnescaper = strings.NewReplacer("%25", "%", "%3B", ";", "%7C", "|", "%0A", "\n")
+
+// escapeField keeps free text from breaking the record and field separators.
+func escapeField(value string) string {
+	return fieldEscaper.Replace(value)
 }
 
-func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
-	for _, account := range s.accounts {
-		if account.Phone == phone {
-			return nil, ErrPhoneRegistered
-		}
-	}
-	s.nextAccountID++
-	account := &types.Account{
-		ID:      s.nextAccountID,
-		Phone:   phone,
-		Balance: 0,
+func unescapeField(value string) string {
+	return fieldUnescaper.Replace(value)
+}
+
+func parseTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, err
 	}
-	s.accounts = append(s.accounts, account)
-	return account, nil
+	return t.UTC(), nil
 }
 
-func (s *Service) Deposit(accountID int64, amount types.Money) error {
-	if amount <= 0 {
-		return ErrAmountMustBePositive
+func parseFavoriteRecord(number int, item []string) (*types.Favorite, error) {
+	if len(item) != 6 && len(item) != 7 {
+		return nil, fmt.Errorf("%w: record %d: expected 6 or 7 fields, got %d", ErrCorruptedExport, number, len(item))
 	}
-	var account *types.Account
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			account = acc
-			break
-		}
+
+	accountID, err := strconv.ParseInt(item[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: record %d: invalid account id %q", ErrCorruptedExport, number, item[2])
 	}
 
-	if account == nil {
-		return ErrAccountNotFound
+	amount, err := strconv.ParseInt(item[4], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: record %d: invalid amount %q", ErrCorruptedExport, number, item[4])
 	}
 
-	account.Balance += amount
-	return nil
+	destinationID := int64(0)
+	if len(item) == 7 {
+		destinationID, err = strconv.ParseInt(item[6], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: record %d: invalid destination id %q", ErrCorruptedExport, number, item[6])
+		}
+	}
+
+	return &types.Favorite{
+		ID:            item[1],
+		AccountID:     accountID,
+		Name:          unescapeField(item[3]),
+		Amount:        types.Money(amount),
+		Category:      types.PaymentCategory(item[5]),
+		DestinationID: destinationID,
+	}, nil
 }
 
-func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
-	if amount <= 0 {
-		return nil, ErrAmountMustBePositive
+func parseTransactionRecord(number int, item []string) (*types.Transaction, error) {
+	if len(item) != 7 {
+		return nil, fmt.Errorf("%w: record %d: expected 7 fields, got %d", ErrCorruptedExport, number, len(item))
 	}
 
-	account, err := s.FindAccountByID(accountID)
+	ID, err := strconv.ParseInt(item[1], 10, 64)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: record %d: invalid id %q", ErrCorruptedExport, number, item[1])
 	}
 
-	if account.Balance < amount {
-		return nil, ErrNotEnoughBalance
+	accountID, err := strconv.ParseInt(item[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: record %d: invalid account id %q", ErrCorruptedExport, number, item[2])
 	}
 
-	account.Balance -= amount
-	paymentID := uuid.New().String()
-	payment := &types.Payment{
-		ID:        paymentID,
-		AccountID: accountID,
-		Amount:    amount,
-		Category:  category,
-		Status:    types.PaymentStatusInProgress,
+	amount, err := strconv.ParseInt(item[4], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: record %d: invalid amount %q", ErrCorruptedExport, number, item[4])
 	}
 
-	s.payments = append(s.payments, payment)
-	return payment, nil
-}
-
-func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
-	for _, account := range s.accounts {
-		if account.ID == accountID {
-			return account, nil
-		}
+	createdAt, err := parseTime(item[6])
+	if err != nil {
+		return nil, fmt.Errorf("%w: record %d: invalid created at %q", ErrCorruptedExport, number, item[6])
 	}
-	return nil, ErrAccountNotFound
+
+	return &types.Transaction{
+		ID:        ID,
+		AccountID: accountID,
+		Kind:      types.TransactionKind(item[3]),
+		Amount:    types.Money(amount),
+		Reference: unescapeField(item[5]),
+		CreatedAt: createdAt,
+	}, nil
 }
 
-func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
-	for _, payment := range s.payments {
-		if payment.ID == paymentID {
-			return payment, nil
-		}
+func parseScheduledRecord(number int, item []string) (*types.ScheduledPayment, error) {
+	if len(item) != 8 {
+		return nil, fmt.Errorf("%w: record %d: expected 8 fields, got %d", ErrCorruptedExport, number, len(item))
 	}
-	return nil, ErrPaymentNotFound
-}
 
-func (s *Service) Reject(paymentID string) error {
-	var payment, err = s.FindPaymentByID(paymentID)
+	accountID, err := strconv.ParseInt(item[2], 10, 64)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%w: record %d: invalid account id %q", ErrCorruptedExport, number, item[2])
 	}
 
-	var account, er = s.FindAccountByID(payment.AccountID)
-	if er != nil {
-		return er
+	amount, err := strconv.ParseInt(item[3], 10, 64)
+	if err != nil || amount <= 0 {
+		return nil, fmt.Errorf("%w: record %d: invalid amount %q", ErrCorruptedExport, number, item[3])
 	}
 
-	payment.Status = types.PaymentStatusFail
-	account.Balance += payment.Amount
+	at, err := parseTime(item[5])
+	if err != nil {
+		return nil, fmt.Errorf("%w: record %d: invalid time %q", ErrCorruptedExport, number, item[5])
+	}
 
-	return nil
+	return &types.ScheduledPayment{
+		ID:        item[1],
+		AccountID: accountID,
+		Amount:    types.Money(amount),
+		Category:  types.PaymentCategory(item[4]),
+		At:        at,
+		Status:    types.PaymentStatus(item[6]),
+		PaymentID: item[7],
+	}, nil
 }
 
-func (s *Service) AddAccountWithBalance(phone types.Phone, balance types.Money) (*types.Account, error) {
-	account, err := s.RegisterAccount(phone)
+func parseRecurringRecord(number int, item []string) (*types.RecurringPayment, error) {
+	if len(item) != 7 {
+		return nil, fmt.Errorf("%w: record %d: expected 7 fields, got %d", ErrCorruptedExport, number, len(item))
+	}
+
+	accountID, err := strconv.ParseInt(item[2], 10, 64)
 	if err != nil {
-		return nil, ErrCannotRegisterAccount
+		return nil, fmt.Errorf("%w: record %d: invalid account id %q", ErrCorruptedExport, number, item[2])
+	}
+
+	amount, err := strconv.ParseInt(item[3], 10, 64)
+	if err != nil || amount <= 0 {
+		return nil, fmt.Errorf("%w: record %d: invalid amount %q", ErrCorruptedExport, number, item[3])
+	}
+
+	interval, err := strconv.ParseInt(item[5], 10, 64)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("%w: record %d: invalid interval %q", ErrCorruptedExport, number, item[5])
 	}
 
-	err = s.Deposit(account.ID, balance)
+	nextRun, err := parseTime(item[6])
 	if err != nil {
-		return nil, ErrCannotDepositAccount
+		return nil, fmt.Errorf("%w: record %d: invalid next run %q", ErrCorruptedExport, number, item[6])
 	}
-	return account, nil
+
+	return &types.RecurringPayment{
+		ID:        item[1],
+		AccountID: accountID,
+		Amount:    types.Money(amount),
+		Category:  types.PaymentCategory(item[4]),
+		Interval:  time.Duration(interval),
+		NextRun:   nextRun,
+	}, nil
 }
 
-func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
-	var targetPayment, err = s.FindPaymentByID(paymentID)
-	if err != nil {
-		return nil, err
+func parseNextAccountIDRecord(number int, item []string) (int64, error) {
+	if len(item) != 2 {
+		return 0, fmt.Errorf("%w: record %d: expected 2 fields, got %d", ErrCorruptedExport, number, len(item))
 	}
 
-	newPayment, err := s.Pay(targetPayment.AccountID, targetPayment.Amount, targetPayment.Category)
+	ID, err := strconv.ParseInt(item[1], 10, 64)
 	if err != nil {
-		return nil, err
+		return 0, fmt.Errorf("%w: record %d: invalid next account id %q", ErrCorruptedExport, number, item[1])
 	}
+	return ID, nil
+}
 
-	return newPayment, nil
+type snapshot struct {
+	NextAccountID  int64
+	Accounts       []*types.Account
+	Payments       []*types.Payment
+	Favorites      []*types.Favorite
+	Refunds        map[string]types.Money
+	Reversals      map[string]string
+	DailyLimits    map[int64]types.Money
+	CategoryLimits map[types.PaymentCategory]types.Money
+	DepositKeys    map[string]bool
+	Scheduled      []*types.ScheduledPayment
+	Recurring      []*types.RecurringPayment
+	NextTxID       int64
+	Transactions   []*types.Transaction
 }
 
-func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorite, error) {
-	payment, err := s.FindPaymentByID(paymentID)
+func (s *Service) ExportToJSON(path string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := json.Marshal(s.snapshot())
 	if err != nil {
-		return nil, err
+		log.Print(err)
+		return err
 	}
 
-	favorite := &types.Favorite{
-		ID:        uuid.New().String(),
-		AccountID: payment.AccountID,
-		Name:      name,
-		Amount:    payment.Amount,
-		Category:  payment.Category,
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		log.Print(err)
+		return err
 	}
-	s.favorites = append(s.favorites, favorite)
-	return favorite, nil
+	return nil
 }
 
-func (s *Service) PayFromFavorite(favoriteID string) (*types.Payment, error) {
-	favorite, err := s.FindFavoriteByID(favoriteID)
+func (s *Service) ImportFromJSON(path string) error {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		return nil, err
+		log.Print(err)
+		return err
 	}
 
-	payment, err := s.Pay(favorite.AccountID, favorite.Amount, favorite.Category)
+	var state snapshot
+	err = json.Unmarshal(data, &state)
 	if err != nil {
-		return nil, err
+		log.Print(err)
+		return err
 	}
-	return payment, nil
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
+	s.restore(state)
+	return nil
 }
 
-func (s *Service) FindFavoriteByID(favoriteID string) (*types.Favorite, error) {
-	for _, favorite := range s.favorites {
-		if favorite.ID == favoriteID {
-			return favorite, nil
-		}
+func (s *Service) snapshot() snapshot {
+	return snapshot{
+		NextAccountID:  s.nextAccountID,
+		Accounts:       s.accounts,
+		Payments:       s.payments,
+		Favorites:      s.favorites,
+		Refunds:        s.refunds,
+		Reversals:      s.reversals,
+		DailyLimits:    s.dailyLimits,
+		CategoryLimits: s.categoryLimits,
+		DepositKeys:    s.depositKeys,
+		Scheduled:      s.scheduled,
+		Recurring:      s.recurring,
+		NextTxID:       s.nextTxID,
+		Transactions:   s.transactions,
 	}
-	return nil, ErrFavoriteNotFound
 }
 
-func (s *Service) getAccounts() []*types.Account {
-	return s.accounts
+func (s *Service) restore(state snapshot) {
+	s.nextAccountID = state.NextAccountID
+	s.accounts = state.Accounts
+	s.payments = state.Payments
+	s.favorites = state.Favorites
+	s.refunds = state.Refunds
+	s.reversals = state.Reversals
+	s.dailyLimits = state.DailyLimits
+	s.categoryLimits = state.CategoryLimits
+	s.depositKeys = state.DepositKeys
+	s.scheduled = state.Scheduled
+	s.recurring = state.Recurring
+	s.nextTxID = state.NextTxID
+	s.transactions = state.Transactions
+	s.reindex()
 }
 
-func (s *Service) ExportToFile(path string) error {
+var accountsCSVHeader = []string{"id", "phone", "balance"}
+
+func (s *Service) ExportAccountsCSV(path string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	file, err := os.Create(path)
 	if err != nil {
 		log.Print(err)
@@ -211,22 +3961,48 @@ func (s *Service) ExportToFile(path string) error {
 		}
 	}()
 
-	for _, account := range s.getAccounts() {
-		ID := strconv.FormatInt(account.ID, 10) + ";"
-		phone := string(account.Phone) + ";"
-		balance := strconv.FormatInt(int64(account.Balance), 10)
-		_, err = file.Write([]byte(ID + phone + balance + "|"))
+	writer := csv.NewWriter(file)
+	err = writer.Write(accountsCSVHeader)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	for _, account := range s.accounts {
+		err = writer.Write([]string{
+			strconv.FormatInt(account.ID, 10),
+			string(account.Phone),
+			strconv.FormatInt(int64(account.Balance), 10),
+		})
 		if err != nil {
 			log.Print(err)
 			return err
 		}
 	}
+	writer.Flush()
+
+	err = writer.Error()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
 	return nil
 }
 
-func (s *Service) ImportFromFile(path string) error {
+var statementCSVHeader = []string{"id", "amount", "category", "status", "created_at"}
+var ledgerCSVHeader = []string{"id", "kind", "amount", "reference", "created_at", "balance"}
+var statementTransactionsCSVHeader = []string{"transaction", "kind", "amount", "reference", "created_at"}
 
-	file, err := os.Open(path)
+// ExportStatement writes a CSV with an account line followed by the account payments and transactions.
+func (s *Service) ExportStatement(accountID int64, path string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
 	if err != nil {
 		log.Print(err)
 		return err
@@ -237,48 +4013,193 @@ func (s *Service) ImportFromFile(path string) error {
 		}
 	}()
 
-	content := make([]byte, 0)
-	buff := make([]byte, 4)
+	writer := csv.NewWriter(file)
+	err = writer.Write([]string{
+		"account",
+		strconv.FormatInt(account.ID, 10),
+		string(account.Phone),
+		strconv.FormatInt(int64(account.Balance), 10),
+		string(account.Currency),
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	err = writer.Write(statementCSVHeader)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	for _, payment := range s.paymentsByAccount(accountID) {
+		err = writer.Write([]string{
+			payment.ID,
+			strconv.FormatInt(int64(payment.Amount), 10),
+			string(payment.Category),
+			string(payment.Status),
+			payment.CreatedAt.Format(time.RFC3339Nano),
+		})
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+	err = writer.Write(statementTransactionsCSVHeader)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	for _, transaction := range s.transactionsByAccount(accountID) {
+		err = writer.Write([]string{
+			strconv.FormatInt(transaction.ID, 10),
+			string(transaction.Kind),
+			strconv.FormatInt(int64(transaction.Amount), 10),
+			transaction.Reference,
+			transaction.CreatedAt.Format(time.RFC3339Nano),
+		})
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+	writer.Flush()
+
+	err = writer.Error()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+// ExportLedger writes the account transactions as CSV with the running balance after each one.
+// Money the account held before transactions were recorded shows up as an opening row.
+func (s *Service) ExportLedger(accountID int64, w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, err := s.findAccountByID(accountID)
+	if err != nil {
+		return err
+	}
+
+	transactions := s.transactionsByAccount(accountID)
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].CreatedAt.Before(transactions[j].CreatedAt)
+	})
+
+	balance := account.Balance
+	for _, transaction := range transactions {
+		balance -= transaction.Amount
+	}
 
-	for {
-		read, err := file.Read(buff)
-		if err == io.EOF {
-			break
+	writer := csv.NewWriter(w)
+	err = writer.Write(ledgerCSVHeader)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	if balance != 0 {
+		err = writer.Write([]string{"", "opening", strconv.FormatInt(int64(balance), 10), "", "", strconv.FormatInt(int64(balance), 10)})
+		if err != nil {
+			log.Print(err)
+			return err
 		}
+	}
+	for _, transaction := range transactions {
+		balance += transaction.Amount
+		err = writer.Write([]string{
+			strconv.FormatInt(transaction.ID, 10),
+			string(transaction.Kind),
+			strconv.FormatInt(int64(transaction.Amount), 10),
+			transaction.Reference,
+			transaction.CreatedAt.Format(time.RFC3339Nano),
+			strconv.FormatInt(int64(balance), 10),
+		})
 		if err != nil {
 			log.Print(err)
 			return err
 		}
-		content = append(content, buff[:read]...)
 	}
-	str := string(content)
-	for _, line := range strings.Split(str, "|") {
-		if len(line) <= 0 {
+	writer.Flush()
+	err = writer.Error()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+func (s *Service) ImportAccountsCSV(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			log.Print(closeErr)
+		}
+	}()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = len(accountsCSVHeader)
+	rows, err := reader.ReadAll()
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrCorruptedExport, err)
+		log.Print(err)
+		return err
+	}
+
+	accounts := make([]*types.Account, 0, len(rows))
+	for i, row := range rows {
+		if i == 0 {
+			continue
+		}
+
+		account, err := parseAccountRecord(i+1, row)
+		if err != nil {
+			log.Print(err)
 			return err
 		}
+		accounts = append(accounts, account)
+	}
 
-		item := strings.Split(line, ";")
-		ID, _ := strconv.ParseInt(item[0], 10, 64)
-		balance, _ := strconv.ParseInt(item[2], 10, 64)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		s.accounts = append(s.accounts, &types.Account{
-			ID:      ID,
-			Phone:   types.Phone(item[1]),
-			Balance: types.Money(balance),
-		})
+	if s.closed {
+		return ErrServiceClosed
 	}
 
-	return err
+	for _, account := range accounts {
+		if account.ID > s.nextAccountID {
+			s.nextAccountID = account.ID
+		}
+		// The CSV has no registration time, so imported accounts count as registered now.
+		account.CreatedAt = s.currentTime()
+		s.addAccount(account)
+	}
+	return nil
 }
 
 func (s *Service) Export(dir string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	log.Print("start exporting accounts entity, count of account: ", len(s.accounts))
 	accExp := 0
 	for _, account := range s.accounts {
 		ID := strconv.FormatInt(account.ID, 10) + ";"
 		phone := string(account.Phone) + ";"
-		balance := strconv.FormatInt(int64(account.Balance), 10)
-		err := WriteToFile(dir+"/accounts.dump", []byte(ID+phone+balance+"\n"))
+		balance := strconv.FormatInt(int64(account.Balance), 10) + ";"
+		currency := string(account.Currency) + ";"
+		frozen := strconv.FormatBool(account.Frozen) + ";"
+		overdraft := strconv.FormatInt(int64(account.OverdraftLimit), 10) + ";"
+		tags := strings.Join(account.Tags, ",") + ";"
+		label := escapeField(account.Label) + ";"
+		createdAt := account.CreatedAt.Format(time.RFC3339Nano) + ";"
+		closed := strconv.FormatBool(account.Closed) + "\n"
+		err := WriteToFile(dir+"/accounts.dump", []byte(ID+phone+balance+currency+frozen+overdraft+tags+label+createdAt+closed))
 		if err != nil {
 			return err
 		}
@@ -293,8 +4214,11 @@ func (s *Service) Export(dir string) error {
 		AccountID := strconv.FormatInt(payment.AccountID, 10) + ";"
 		Amount := strconv.FormatInt(int64(payment.Amount), 10) + ";"
 		Category := string(payment.Category) + ";"
-		Status := string(payment.Status) + "\n"
-		err := WriteToFile(dir+"/payments.dump", []byte(ID+AccountID+Amount+Category+Status))
+		Status := string(payment.Status) + ";"
+		CreatedAt := payment.CreatedAt.Format(time.RFC3339Nano) + ";"
+		Description := escapeField(payment.Description) + ";"
+		Fee := strconv.FormatInt(int64(payment.Fee), 10) + "\n"
+		err := WriteToFile(dir+"/payments.dump", []byte(ID+AccountID+Amount+Category+Status+CreatedAt+Description+Fee))
 		if err != nil {
 			return err
 		}
@@ -307,16 +4231,30 @@ func (s *Service) Export(dir string) error {
 	for _, favorite := range s.favorites {
 		ID := favorite.ID + ";"
 		AccountID := strconv.FormatInt(favorite.AccountID, 10) + ";"
-		Name := favorite.Name + ";"
+		Name := escapeField(favorite.Name) + ";"
 		Amount := strconv.FormatInt(int64(favorite.Amount), 10) + ";"
-		Category := string(favorite.Category) + "\n"
-		err := WriteToFile(dir+"/favorites.dump", []byte(ID+AccountID+Name+Amount+Category))
+		Category := string(favorite.Category) + ";"
+		DestinationID := strconv.FormatInt(favorite.DestinationID, 10) + "\n"
+		err := WriteToFile(dir+"/favorites.dump", []byte(ID+AccountID+Name+Amount+Category+DestinationID))
 		favExp++
 		if err != nil {
 			return err
 		}
 	}
 	log.Print("end of exporting favorites entity, amount of exported fav: ", favExp)
+
+	for _, transaction := range s.transactions {
+		ID := strconv.FormatInt(transaction.ID, 10) + ";"
+		AccountID := strconv.FormatInt(transaction.AccountID, 10) + ";"
+		Kind := string(transaction.Kind) + ";"
+		Amount := strconv.FormatInt(int64(transaction.Amount), 10) + ";"
+		Reference := escapeField(transaction.Reference) + ";"
+		CreatedAt := transaction.CreatedAt.Format(time.RFC3339Nano) + "\n"
+		err := WriteToFile(dir+"/transactions.dump", []byte(ID+AccountID+Kind+Amount+Reference+CreatedAt))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -349,6 +4287,13 @@ func WriteToFile(fileName string, data []byte) error {
 }
 
 func (s *Service) Import(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrServiceClosed
+	}
+
 	log.Print("account count in the start of import method: ", len(s.accounts))
 	log.Print("Start Import method with param: " + dir)
 	files, err := ioutil.ReadDir(dir)
@@ -387,7 +4332,7 @@ func (s *Service) Import(dir string) error {
 			case "accounts.dump":
 				acc := s.convertToAccount(item)
 				if acc != nil {
-					s.accounts = append(s.accounts, acc)
+					s.addAccount(acc)
 				}
 			case "favorites.dump":
 				favorite := s.convertToFavorites(item)
@@ -397,7 +4342,12 @@ func (s *Service) Import(dir string) error {
 			case "payments.dump":
 				payment := s.convertToPayments(item)
 				if payment != nil {
-					s.payments = append(s.payments, payment)
+					s.addPayment(payment)
+				}
+			case "transactions.dump":
+				transaction := s.convertToTransaction(item)
+				if transaction != nil {
+					s.transactions = append(s.transactions, transaction)
 				}
 			default:
 				break
@@ -412,65 +4362,158 @@ func (s *Service) Import(dir string) error {
 func (s *Service) convertToAccount(item []string) *types.Account {
 	ID, _ := strconv.ParseInt(item[0], 10, 64)
 	balance, _ := strconv.ParseInt(removeEndLine(item[2]), 10, 64)
-	account, err := s.FindAccountByID(ID)
+	currency := types.Currency("")
+	if len(item) > 3 {
+		currency = types.Currency(removeEndLine(item[3]))
+	}
+	frozen := false
+	if len(item) > 4 {
+		frozen, _ = strconv.ParseBool(removeEndLine(item[4]))
+	}
+	overdraft := int64(0)
+	if len(item) > 5 {
+		overdraft, _ = strconv.ParseInt(removeEndLine(item[5]), 10, 64)
+	}
+	var tags []string
+	if len(item) > 6 {
+		tags = parseTags(removeEndLine(item[6]))
+	}
+	label := ""
+	if len(item) > 7 {
+		label = unescapeField(removeEndLine(item[7]))
+	}
+	createdAt := time.Time{}
+	if len(item) > 8 {
+		createdAt, _ = parseTime(removeEndLine(item[8]))
+	}
+	closed := false
+	if len(item) > 9 {
+		closed, _ = strconv.ParseBool(removeEndLine(item[9]))
+	}
+	account, err := s.findAccountByID(ID)
 	if err != nil {
 		s.nextAccountID++
 		return &types.Account{
-			ID:      ID,
-			Phone:   types.Phone(item[1]),
-			Balance: types.Money(balance),
+			ID:             ID,
+			Phone:          types.Phone(item[1]),
+			Balance:        types.Money(balance),
+			Currency:       currency,
+			Frozen:         frozen,
+			Closed:         closed,
+			OverdraftLimit: types.Money(overdraft),
+			Tags:           tags,
+			Label:          label,
+			CreatedAt:      createdAt,
 		}
 	}
 	account.ID = ID
 	account.Phone = types.Phone(item[1])
 	account.Balance = types.Money(balance)
+	account.Currency = currency
+	account.Frozen = frozen
+	account.Closed = closed
+	account.OverdraftLimit = types.Money(overdraft)
+	account.Tags = tags
+	account.Label = label
+	account.CreatedAt = createdAt
 	return nil
 }
 
 func (s *Service) convertToFavorites(item []string) *types.Favorite {
 	AccountID, _ := strconv.ParseInt(item[1], 10, 64)
 	Amount, _ := strconv.ParseInt(item[3], 10, 64)
+	DestinationID := int64(0)
+	if len(item) > 5 {
+		DestinationID, _ = strconv.ParseInt(removeEndLine(item[5]), 10, 64)
+	}
 
-	favorite, err := s.FindFavoriteByID(item[0])
+	favorite, err := s.findFavoriteByID(item[0])
 	if err != nil {
 		return &types.Favorite{
-			ID:        item[0],
-			AccountID: AccountID,
-			Name:      item[2],
-			Amount:    types.Money(Amount),
-			Category:  types.PaymentCategory(item[4]),
+			ID:            item[0],
+			AccountID:     AccountID,
+			Name:          unescapeField(item[2]),
+			Amount:        types.Money(Amount),
+			Category:      types.PaymentCategory(removeEndLine(item[4])),
+			DestinationID: DestinationID,
 		}
 	}
 	favorite.ID = item[0]
 	favorite.AccountID = AccountID
-	favorite.Name = item[2]
+	favorite.Name = unescapeField(item[2])
 	favorite.Amount = types.Money(Amount)
 	favorite.Category = types.PaymentCategory(removeEndLine(item[4]))
+	favorite.DestinationID = DestinationID
 	return nil
 }
 
 func (s *Service) convertToPayments(item []string) *types.Payment {
 	AccountID, _ := strconv.ParseInt(item[1], 10, 64)
 	Amount, _ := strconv.ParseInt(item[2], 10, 64)
+	CreatedAt := time.Time{}
+	if len(item) > 5 {
+		CreatedAt, _ = parseTime(removeEndLine(item[5]))
+	}
+	Description := ""
+	if len(item) > 6 {
+		Description = unescapeField(removeEndLine(item[6]))
+	}
+	Fee := int64(0)
+	if len(item) > 7 {
+		Fee, _ = strconv.ParseInt(removeEndLine(item[7]), 10, 64)
+	}
 
-	payment, err := s.FindPaymentByID(item[0])
+	payment, err := s.findPaymentByID(item[0])
 	if err != nil {
 		return &types.Payment{
-			ID:        item[0],
-			AccountID: AccountID,
-			Amount:    types.Money(Amount),
-			Category:  types.PaymentCategory(item[3]),
-			Status:    types.PaymentStatus(removeEndLine(item[4])),
+			ID:          item[0],
+			AccountID:   AccountID,
+			Amount:      types.Money(Amount),
+			Category:    types.PaymentCategory(item[3]),
+			Status:      types.PaymentStatus(removeEndLine(item[4])),
+			CreatedAt:   CreatedAt,
+			Description: Description,
+			Fee:         types.Money(Fee),
 		}
 	}
 	payment.ID = item[0]
 	payment.AccountID = AccountID
 	payment.Amount = types.Money(Amount)
 	payment.Category = types.PaymentCategory(item[3])
-	payment.Status = types.PaymentStatus(item[4])
+	payment.Status = types.PaymentStatus(removeEndLine(item[4]))
+	payment.CreatedAt = CreatedAt
+	payment.Description = Description
+	payment.Fee = types.Money(Fee)
 	return nil
 }
 
+func (s *Service) convertToTransaction(item []string) *types.Transaction {
+	if len(item) < 6 {
+		return nil
+	}
+	ID, _ := strconv.ParseInt(item[0], 10, 64)
+	AccountID, _ := strconv.ParseInt(item[1], 10, 64)
+	Amount, _ := strconv.ParseInt(item[3], 10, 64)
+	CreatedAt, _ := parseTime(removeEndLine(item[5]))
+
+	for _, transaction := range s.transactions {
+		if transaction.ID == ID {
+			return nil
+		}
+	}
+	if ID > s.nextTxID {
+		s.nextTxID = ID
+	}
+	return &types.Transaction{
+		ID:        ID,
+		AccountID: AccountID,
+		Kind:      types.TransactionKind(item[2]),
+		Amount:    types.Money(Amount),
+		Reference: unescapeField(item[4]),
+		CreatedAt: CreatedAt,
+	}
+}
+
 func removeEndLine(balance string) string {
 	return strings.TrimRightFunc(balance, func(c rune) bool {
 		return c == '\r' || c == '\n'
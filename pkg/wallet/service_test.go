@@ -1,13 +1,22 @@
 package wallet
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"errors"
 	"github.com/bdaler/wallet/pkg/types"
 	"github.com/google/uuid"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var defaultFavorite = types.Favorite{
@@ -141,9 +150,10 @@ func TestService_RegisterAccount(t *testing.T) {
 			fields: fields{},
 			args:   args{phone: "9127660305"},
 			want: &types.Account{
-				ID:      1,
-				Phone:   "9127660305",
-				Balance: 0,
+				ID:        1,
+				Phone:     "9127660305",
+				Balance:   0,
+				CreatedAt: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC),
 			},
 			wantErr: false,
 		},
@@ -167,7 +177,9 @@ func TestService_RegisterAccount(t *testing.T) {
 				nextAccountID: tt.fields.nextAccountID,
 				accounts:      tt.fields.accounts,
 				payments:      tt.fields.payments,
+				now:           func() time.Time { return time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC) },
 			}
+			s.reindex()
 			got, err := s.RegisterAccount(tt.args.phone)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("RegisterAccount() error = %v, wantErr %v", err, tt.wantErr)
@@ -245,6 +257,7 @@ func TestService_Deposit(t *testing.T) {
 				accounts:      tt.fields.accounts,
 				payments:      tt.fields.payments,
 			}
+			s.reindex()
 			if err := s.Deposit(tt.args.accountID, tt.args.amount); (err != nil) != tt.wantErr {
 				t.Errorf("Deposit() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -277,6 +290,7 @@ func TestService_FindAccountByID(t *testing.T) {
 				accounts:      tt.fields.accounts,
 				payments:      tt.fields.payments,
 			}
+			s.reindex()
 			got, err := s.FindAccountByID(tt.args.accountID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("FindAccountByID() error = %v, wantErr %v", err, tt.wantErr)
@@ -314,6 +328,7 @@ func TestService_FindPaymentByID(t *testing.T) {
 				accounts:      tt.fields.accounts,
 				payments:      tt.fields.payments,
 			}
+			s.reindex()
 			got, err := s.FindPaymentByID(tt.args.paymentID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("FindPaymentByID() error = %v, wantErr %v", err, tt.wantErr)
@@ -393,6 +408,7 @@ func TestService_Pay(t *testing.T) {
 				accounts:      tt.fields.accounts,
 				payments:      tt.fields.payments,
 			}
+			s.reindex()
 			got, err := s.Pay(tt.args.accountID, tt.args.amount, tt.args.category)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Pay() error = %v, wantErr %v", err, tt.wantErr)
@@ -429,6 +445,7 @@ func TestService_Reject(t *testing.T) {
 				accounts:      tt.fields.accounts,
 				payments:      tt.fields.payments,
 			}
+			s.reindex()
 			if err := s.Reject(tt.args.paymentID); (err != nil) != tt.wantErr {
 				t.Errorf("Reject() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -571,6 +588,7 @@ func TestService_FindFavoriteByID(t *testing.T) {
 				payments:      tt.fields.payments,
 				favorites:     tt.fields.favorites,
 			}
+			s.reindex()
 			got, err := s.FindFavoriteByID(tt.args.favoriteID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("FindFavoriteByID() error = %v, wantErr %v", err, tt.wantErr)
@@ -709,3 +727,4044 @@ func TestService_Import2(t *testing.T) {
 	//	return
 	//}
 }
+
+func TestService_Withdraw(t *testing.T) {
+	type fields struct {
+		accounts []*types.Account
+	}
+	type args struct {
+		accountID int64
+		amount    types.Money
+	}
+	tests := []struct {
+		name        string
+		fields      fields
+		args        args
+		wantBalance types.Money
+		wantErr     error
+	}{
+		{
+			name:    "amount must be greater than zero",
+			fields:  fields{accounts: Accounts()},
+			args:    args{accountID: 4, amount: 0},
+			wantErr: ErrAmountMustBePositive,
+		},
+		{
+			name:    "account not found",
+			fields:  fields{accounts: Accounts()},
+			args:    args{accountID: 10, amount: 1},
+			wantErr: ErrAccountNotFound,
+		},
+		{
+			name:    "not enough balance in account",
+			fields:  fields{accounts: Accounts()},
+			args:    args{accountID: 4, amount: 4},
+			wantErr: ErrNotEnoughBalance,
+		},
+		{
+			name:        "success",
+			fields:      fields{accounts: Accounts()},
+			args:        args{accountID: 4, amount: 2},
+			wantBalance: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{
+				accounts: tt.fields.accounts,
+			}
+			s.reindex()
+			err := s.Withdraw(tt.args.accountID, tt.args.amount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Withdraw() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			account, _ := s.FindAccountByID(tt.args.accountID)
+			if account.Balance != tt.wantBalance {
+				t.Errorf("Withdraw() balance = %v, want %v", account.Balance, tt.wantBalance)
+			}
+			if len(s.payments) != 0 {
+				t.Errorf("Withdraw() created payments = %v", s.payments)
+			}
+		})
+	}
+}
+
+func TestService_Transfer(t *testing.T) {
+	type args struct {
+		fromID int64
+		toID   int64
+		amount types.Money
+	}
+	tests := []struct {
+		name         string
+		args         args
+		wantBalances map[int64]types.Money
+		wantErr      error
+	}{
+		{
+			name:         "amount must be greater than zero",
+			args:         args{fromID: 4, toID: 3, amount: 0},
+			wantBalances: map[int64]types.Money{3: 2, 4: 3},
+			wantErr:      ErrAmountMustBePositive,
+		},
+		{
+			name:         "same account",
+			args:         args{fromID: 4, toID: 4, amount: 1},
+			wantBalances: map[int64]types.Money{4: 3},
+			wantErr:      ErrSameAccount,
+		},
+		{
+			name:         "source account not found",
+			args:         args{fromID: 10, toID: 4, amount: 1},
+			wantBalances: map[int64]types.Money{4: 3},
+			wantErr:      ErrAccountNotFound,
+		},
+		{
+			name:         "destination account not found",
+			args:         args{fromID: 4, toID: 10, amount: 1},
+			wantBalances: map[int64]types.Money{4: 3},
+			wantErr:      ErrAccountNotFound,
+		},
+		{
+			name:         "not enough balance in account",
+			args:         args{fromID: 4, toID: 3, amount: 4},
+			wantBalances: map[int64]types.Money{3: 2, 4: 3},
+			wantErr:      ErrNotEnoughBalance,
+		},
+		{
+			name:         "success",
+			args:         args{fromID: 4, toID: 3, amount: 3},
+			wantBalances: map[int64]types.Money{3: 5, 4: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{
+				accounts: Accounts(),
+			}
+			s.reindex()
+			_, err := s.Transfer(tt.args.fromID, tt.args.toID, tt.args.amount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Transfer() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			for id, want := range tt.wantBalances {
+				account, _ := s.FindAccountByID(id)
+				if account.Balance != want {
+					t.Errorf("Transfer() balance of %d = %v, want %v", id, account.Balance, want)
+				}
+			}
+		})
+	}
+}
+
+func TestService_TotalBalance(t *testing.T) {
+	tests := []struct {
+		name     string
+		accounts []*types.Account
+		want     types.Money
+	}{
+		{
+			name:     "no accounts",
+			accounts: nil,
+			want:     0,
+		},
+		{
+			name:     "sum of all balances",
+			accounts: Accounts(),
+			want:     6,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{
+				accounts: tt.accounts,
+			}
+			s.reindex()
+			if got := s.TotalBalance(); got != tt.want {
+				t.Errorf("TotalBalance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_SumPayments(t *testing.T) {
+	var payments []*types.Payment
+	for i := 1; i <= 100; i++ {
+		payments = append(payments, &types.Payment{ID: strconv.Itoa(i), Amount: types.Money(i)})
+	}
+
+	tests := []struct {
+		name       string
+		payments   []*types.Payment
+		goroutines int
+		want       types.Money
+	}{
+		{name: "no payments", payments: nil, goroutines: 4, want: 0},
+		{name: "single goroutine", payments: payments, goroutines: 1, want: 5050},
+		{name: "uneven chunks", payments: payments, goroutines: 7, want: 5050},
+		{name: "more goroutines than payments", payments: payments[:3], goroutines: 10, want: 6},
+		{name: "non-positive goroutines", payments: payments, goroutines: 0, want: 5050},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{
+				payments: tt.payments,
+			}
+			if got := s.SumPayments(tt.goroutines); got != tt.want {
+				t.Errorf("SumPayments() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_concurrentAccess(t *testing.T) {
+	s := newTestService()
+	account, err := s.AddAccountWithBalance("9127660305", 1_000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(5)
+		go func() {
+			defer wg.Done()
+			_ = s.Deposit(account.ID, 10)
+		}()
+		go func(i int) {
+			defer wg.Done()
+			_, _ = s.RegisterAccount(types.Phone("91276603" + strconv.Itoa(10+i)))
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, _ = s.FindAccountByID(account.ID)
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = s.Pay(account.ID, 10, types.CategoryFood)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = s.TotalBalance()
+		}()
+	}
+	wg.Wait()
+
+	if s.TotalBalance() != 1_000 {
+		t.Errorf("TotalBalance() = %v, want %v", s.TotalBalance(), 1_000)
+	}
+	if len(s.payments) != 100 {
+		t.Errorf("len(payments) = %v, want %v", len(s.payments), 100)
+	}
+}
+
+func TestService_ExportToJSON_ImportFromJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallet.json")
+
+	s := newTestService()
+	account1, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account1.ID, 10, types.CategoryIt)
+	_, _ = s.FavoritePayment(payment.ID, types.CategoryIt)
+	_ = s.Reject(payment.ID)
+
+	account2, _ := s.AddAccountWithBalance("9127660306", 11)
+	payment2, _ := s.Pay(account2.ID, 10, types.CategoryFood)
+	_, _ = s.FavoritePayment(payment2.ID, types.CategoryFood)
+
+	err := s.ExportToJSON(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	i := newTestService()
+	_, _ = i.AddAccountWithBalance("9127660399", 1)
+	err = i.ImportFromJSON(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if i.nextAccountID != s.nextAccountID {
+		t.Errorf("nextAccountID = %v, want %v", i.nextAccountID, s.nextAccountID)
+	}
+	if !reflect.DeepEqual(s.accounts, i.accounts) {
+		t.Error(errors.New("imported and exported accounts doesn't match"))
+	}
+	if !reflect.DeepEqual(s.payments, i.payments) {
+		t.Error(errors.New("imported and exported payments doesn't match"))
+	}
+	if !reflect.DeepEqual(s.favorites, i.favorites) {
+		t.Error(errors.New("imported and exported favorites doesn't match"))
+	}
+}
+
+func TestService_ImportFromFile_corrupted(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "missing field", content: "1;9127660305;10|2;9127660306|"},
+		{name: "malformed balance", content: "1;9127660305;10|2;9127660306;1x|"},
+		{name: "malformed id", content: "x;9127660305;10|"},
+		{name: "extra field", content: "1;9127660305;10;TJS;false;0;;;1|"},
+		{name: "negative balance", content: "1;9127660305;10|2;9127660306;-5|"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "accounts.txt")
+			err := ioutil.WriteFile(path, []byte(tt.content), 0644)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			s := newTestService()
+			err = s.ImportFromFile(path)
+			if !errors.Is(err, ErrCorruptedExport) {
+				t.Errorf("ImportFromFile() error = %v, want %v", err, ErrCorruptedExport)
+			}
+			if len(s.accounts) != 0 {
+				t.Errorf("ImportFromFile() loaded accounts = %v", s.accounts)
+			}
+		})
+	}
+}
+
+func TestService_ImportFromFile_trailingSeparator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+	err := ioutil.WriteFile(path, []byte("1;9127660305;10||2;9127660306;11|"), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s := newTestService()
+	err = s.ImportFromFile(path)
+	if err != nil {
+		t.Errorf("ImportFromFile() error = %v", err)
+		return
+	}
+
+	want := []*types.Account{
+		{ID: 1, Phone: "9127660305", Balance: 10},
+		{ID: 2, Phone: "9127660306", Balance: 11},
+	}
+	if !reflect.DeepEqual(s.accounts, want) {
+		t.Errorf("ImportFromFile() accounts = %v, want %v", s.accounts, want)
+	}
+}
+
+func TestService_ExportToFile_ImportFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+
+	s := newTestService()
+	s.BaseCurrency = "TJS"
+	account1, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account1.ID, 10, types.CategoryIt)
+	favorite, _ := s.FavoritePayment(payment.ID, types.CategoryIt)
+	_ = s.Reject(payment.ID)
+	account2, _ := s.AddAccountWithBalance("9127660306", 11)
+	_, _ = s.Pay(account2.ID, 10, types.CategoryFood)
+	_ = s.FreezeAccount(account2.ID)
+
+	err := s.ExportToFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	i := newTestService()
+	err = i.ImportFromFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !reflect.DeepEqual(s.accounts, i.accounts) {
+		t.Error(errors.New("imported and exported accounts doesn't match"))
+	}
+	if !reflect.DeepEqual(s.payments, i.payments) {
+		t.Error(errors.New("imported and exported payments doesn't match"))
+	}
+	if !reflect.DeepEqual(s.favorites, i.favorites) {
+		t.Error(errors.New("imported and exported favorites doesn't match"))
+	}
+
+	if _, err = i.FindPaymentByID(payment.ID); err != nil {
+		t.Errorf("FindPaymentByID() error = %v", err)
+	}
+	if _, err = i.FindFavoriteByID(favorite.ID); err != nil {
+		t.Errorf("FindFavoriteByID() error = %v", err)
+	}
+
+	account3, err := i.RegisterAccount("9127660307")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account3.ID != 3 {
+		t.Errorf("RegisterAccount() ID = %v, want %v", account3.ID, 3)
+	}
+}
+
+func TestService_DeleteAccount(t *testing.T) {
+	s := newTestService()
+	account1, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment1, _ := s.Pay(account1.ID, 10, types.CategoryIt)
+	favorite1, _ := s.FavoritePayment(payment1.ID, types.CategoryIt)
+	account2, _ := s.AddAccountWithBalance("9127660306", 100)
+	payment2, _ := s.Pay(account2.ID, 10, types.CategoryIt)
+	favorite2, _ := s.FavoritePayment(payment2.ID, types.CategoryIt)
+
+	err := s.DeleteAccount(10)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("DeleteAccount() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	err = s.DeleteAccount(account1.ID)
+	if err != nil {
+		t.Errorf("DeleteAccount() error = %v", err)
+		return
+	}
+
+	if _, err = s.FindAccountByID(account1.ID); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("FindAccountByID() error = %v, want %v", err, ErrAccountNotFound)
+	}
+	if _, err = s.FindPaymentByID(payment1.ID); !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("FindPaymentByID() error = %v, want %v", err, ErrPaymentNotFound)
+	}
+	if _, err = s.FindFavoriteByID(favorite1.ID); !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("FindFavoriteByID() error = %v, want %v", err, ErrFavoriteNotFound)
+	}
+
+	if _, err = s.FindAccountByID(account2.ID); err != nil {
+		t.Errorf("FindAccountByID() error = %v", err)
+	}
+	if _, err = s.FindPaymentByID(payment2.ID); err != nil {
+		t.Errorf("FindPaymentByID() error = %v", err)
+	}
+	if _, err = s.FindFavoriteByID(favorite2.ID); err != nil {
+		t.Errorf("FindFavoriteByID() error = %v", err)
+	}
+}
+
+func TestService_PaymentsByAccount(t *testing.T) {
+	s := newTestService()
+	account1, _ := s.AddAccountWithBalance("9127660305", 100)
+	account2, _ := s.AddAccountWithBalance("9127660306", 100)
+	account3, _ := s.AddAccountWithBalance("9127660307", 100)
+	payment1, _ := s.Pay(account1.ID, 10, types.CategoryIt)
+	_, _ = s.Pay(account2.ID, 10, types.CategoryIt)
+	payment3, _ := s.Pay(account1.ID, 20, types.CategoryFood)
+
+	_, err := s.PaymentsByAccount(10)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("PaymentsByAccount() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	got, err := s.PaymentsByAccount(account1.ID)
+	if err != nil {
+		t.Errorf("PaymentsByAccount() error = %v", err)
+		return
+	}
+	want := []*types.Payment{payment1, payment3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PaymentsByAccount() got = %v, want %v", got, want)
+	}
+
+	got, err = s.PaymentsByAccount(account3.ID)
+	if err != nil {
+		t.Errorf("PaymentsByAccount() error = %v", err)
+		return
+	}
+	if got == nil || len(got) != 0 {
+		t.Errorf("PaymentsByAccount() got = %v, want empty slice", got)
+	}
+}
+
+func TestService_SpendingByCategory(t *testing.T) {
+	s := newTestService()
+	account1, _ := s.AddAccountWithBalance("9127660305", 100)
+	account2, _ := s.AddAccountWithBalance("9127660306", 100)
+	_, _ = s.Pay(account1.ID, 10, types.CategoryIt)
+	_, _ = s.Pay(account1.ID, 15, types.CategoryIt)
+	_, _ = s.Pay(account1.ID, 20, types.CategoryFood)
+	failed, _ := s.Pay(account1.ID, 30, types.CategoryShop)
+	_ = s.Reject(failed.ID)
+	_, _ = s.Pay(account2.ID, 5, types.CategoryIt)
+
+	_, err := s.SpendingByCategory(10)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("SpendingByCategory() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	got, err := s.SpendingByCategory(account1.ID)
+	if err != nil {
+		t.Errorf("SpendingByCategory() error = %v", err)
+		return
+	}
+	want := map[types.PaymentCategory]types.Money{
+		types.CategoryIt:   25,
+		types.CategoryFood: 20,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SpendingByCategory() got = %v, want %v", got, want)
+	}
+
+	account3, _ := s.RegisterAccount("9127660307")
+	got, err = s.SpendingByCategory(account3.ID)
+	if err != nil || got == nil || len(got) != 0 {
+		t.Errorf("SpendingByCategory() got = %v, %v, want empty map", got, err)
+	}
+}
+
+func TestService_Reject_twice(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account.ID, 10, types.CategoryIt)
+
+	err := s.Reject(payment.ID)
+	if err != nil {
+		t.Errorf("Reject() error = %v", err)
+		return
+	}
+
+	err = s.Reject(payment.ID)
+	if !errors.Is(err, ErrPaymentAlreadyRejected) {
+		t.Errorf("Reject() error = %v, want %v", err, ErrPaymentAlreadyRejected)
+	}
+
+	if account.Balance != 100 {
+		t.Errorf("Reject() balance = %v, want %v", account.Balance, 100)
+	}
+}
+
+func TestService_Deposit_maxBalance(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 90)
+	s.SetMaxBalance(100)
+
+	err := s.Deposit(account.ID, 11)
+	if !errors.Is(err, ErrBalanceLimitExceeded) {
+		t.Errorf("Deposit() error = %v, want %v", err, ErrBalanceLimitExceeded)
+	}
+	if account.Balance != 90 {
+		t.Errorf("Deposit() balance = %v, want %v", account.Balance, 90)
+	}
+
+	err = s.Deposit(account.ID, 10)
+	if err != nil {
+		t.Errorf("Deposit() error = %v", err)
+	}
+	if account.Balance != 100 {
+		t.Errorf("Deposit() balance = %v, want %v", account.Balance, 100)
+	}
+
+	s.SetMaxBalance(0)
+	err = s.Deposit(account.ID, 1_000)
+	if err != nil {
+		t.Errorf("Deposit() error = %v", err)
+	}
+}
+
+func TestService_UpdatePhone(t *testing.T) {
+	s := newTestService()
+	account1, _ := s.RegisterAccount("9127660305")
+	_, _ = s.RegisterAccount("9127660306")
+
+	err := s.UpdatePhone(10, "9127660307")
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("UpdatePhone() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	err = s.UpdatePhone(account1.ID, "9127660306")
+	if !errors.Is(err, ErrPhoneRegistered) {
+		t.Errorf("UpdatePhone() error = %v, want %v", err, ErrPhoneRegistered)
+	}
+
+	err = s.UpdatePhone(account1.ID, "9127660305")
+	if err != nil {
+		t.Errorf("UpdatePhone() error = %v", err)
+	}
+
+	err = s.UpdatePhone(account1.ID, "9127660307")
+	if err != nil {
+		t.Errorf("UpdatePhone() error = %v", err)
+	}
+	if account1.Phone != "9127660307" {
+		t.Errorf("UpdatePhone() phone = %v, want %v", account1.Phone, "9127660307")
+	}
+}
+
+func TestService_FindAccountByPhone(t *testing.T) {
+	tests := []struct {
+		name    string
+		phone   types.Phone
+		wantID  int64
+		wantErr error
+	}{
+		{name: "found", phone: "9127660306", wantID: 2},
+		{name: "first match", phone: "9127660307", wantID: 3},
+		{name: "not found", phone: "9127660399", wantErr: ErrAccountNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{
+				accounts: Accounts(),
+			}
+			s.reindex()
+			got, err := s.FindAccountByPhone(tt.phone)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("FindAccountByPhone() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.ID != tt.wantID {
+				t.Errorf("FindAccountByPhone() got = %v, want ID %v", got, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestService_FindAccountByPhone_subAccount(t *testing.T) {
+	s := newTestService()
+	savings, _ := s.RegisterSubAccount("9127660305", "savings")
+
+	got, err := s.FindAccountByPhone("9127660305")
+	if err != nil || got != savings {
+		t.Errorf("FindAccountByPhone() got = %v, %v, want sub-account %v", got, err, savings)
+	}
+
+	main, _ := s.RegisterAccount("9127660305")
+	got, err = s.FindAccountByPhone("912-766-0305")
+	if err != nil || got != main {
+		t.Errorf("FindAccountByPhone() got = %v, %v, want unlabelled %v", got, err, main)
+	}
+}
+
+func BenchmarkService_FindAccountByID(b *testing.B) {
+	s := newTestService()
+	for i := 1; i <= 50_000; i++ {
+		s.addAccount(&types.Account{ID: int64(i), Phone: types.Phone(strconv.Itoa(i))})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := s.FindAccountByID(int64(i%50_000) + 1)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkService_FindPaymentByID(b *testing.B) {
+	s := newTestService()
+	for i := 1; i <= 50_000; i++ {
+		s.addPayment(&types.Payment{ID: strconv.Itoa(i), AccountID: 1, Amount: 1})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := s.FindPaymentByID(strconv.Itoa(i%50_000 + 1))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkService_ExportToFile(b *testing.B) {
+	s := newTestService()
+	for i := 1; i <= 10_000; i++ {
+		s.addAccount(&types.Account{ID: int64(i), Phone: types.Phone(strconv.Itoa(i)), Balance: types.Money(i)})
+	}
+	path := filepath.Join(b.TempDir(), "accounts.txt")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := s.ExportToFile(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkService_ImportFromFile(b *testing.B) {
+	s := newTestService()
+	for i := 1; i <= 10_000; i++ {
+		s.addAccount(&types.Account{ID: int64(i), Phone: types.Phone(strconv.Itoa(i)), Balance: types.Money(i)})
+	}
+	path := filepath.Join(b.TempDir(), "accounts.txt")
+	err := s.ExportToFile(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := newTestService().ImportFromFile(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestService_PartialRefund(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account.ID, 50, types.CategoryIt)
+
+	err := s.PartialRefund("nonExistingPaymentID", 10)
+	if !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("PartialRefund() error = %v, want %v", err, ErrPaymentNotFound)
+	}
+
+	err = s.PartialRefund(payment.ID, 51)
+	if !errors.Is(err, ErrAmountExceedsPayment) {
+		t.Errorf("PartialRefund() error = %v, want %v", err, ErrAmountExceedsPayment)
+	}
+
+	err = s.PartialRefund(payment.ID, 20)
+	if err != nil {
+		t.Errorf("PartialRefund() error = %v", err)
+		return
+	}
+	if account.Balance != 70 || payment.Status != types.PaymentStatusInProgress {
+		t.Errorf("PartialRefund() balance = %v, status = %v", account.Balance, payment.Status)
+	}
+
+	err = s.PartialRefund(payment.ID, 31)
+	if !errors.Is(err, ErrAmountExceedsPayment) {
+		t.Errorf("PartialRefund() error = %v, want %v", err, ErrAmountExceedsPayment)
+	}
+
+	err = s.PartialRefund(payment.ID, 30)
+	if err != nil {
+		t.Errorf("PartialRefund() error = %v", err)
+		return
+	}
+	if account.Balance != 100 || payment.Status != types.PaymentStatusFail {
+		t.Errorf("PartialRefund() balance = %v, status = %v", account.Balance, payment.Status)
+	}
+}
+
+func TestService_PartialRefund_fee(t *testing.T) {
+	s := NewService(WithFeeRate(0.1))
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account.ID, 50, types.CategoryIt)
+	if account.Balance != 45 {
+		t.Errorf("Pay() balance = %v, want %v", account.Balance, 45)
+		return
+	}
+
+	_ = s.PartialRefund(payment.ID, 20)
+	if account.Balance != 65 {
+		t.Errorf("PartialRefund() balance = %v, want %v", account.Balance, 65)
+	}
+	err := s.PartialRefund(payment.ID, 30)
+	if err != nil {
+		t.Errorf("PartialRefund() error = %v", err)
+		return
+	}
+	if account.Balance != 100 || payment.Status != types.PaymentStatusFail {
+		t.Errorf("PartialRefund() balance = %v, status = %v, want 100 and FAIL", account.Balance, payment.Status)
+	}
+	if last := s.transactions[len(s.transactions)-1]; last.Amount != 35 {
+		t.Errorf("PartialRefund() transaction amount = %v, want %v", last.Amount, 35)
+	}
+}
+
+func TestService_Reject_afterPartialRefund(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account.ID, 50, types.CategoryIt)
+	_ = s.PartialRefund(payment.ID, 20)
+
+	err := s.Reject(payment.ID)
+	if err != nil {
+		t.Errorf("Reject() error = %v", err)
+		return
+	}
+	if account.Balance != 100 {
+		t.Errorf("Reject() balance = %v, want %v", account.Balance, 100)
+	}
+}
+
+func TestService_RemoveFavorite(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account.ID, 10, types.CategoryIt)
+	favorite1, _ := s.FavoritePayment(payment.ID, types.CategoryIt)
+	favorite2, _ := s.FavoritePayment(payment.ID, types.CategoryFood)
+
+	err := s.RemoveFavorite("nonExistingFavoriteID")
+	if !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("RemoveFavorite() error = %v, want %v", err, ErrFavoriteNotFound)
+	}
+
+	err = s.RemoveFavorite(favorite1.ID)
+	if err != nil {
+		t.Errorf("RemoveFavorite() error = %v", err)
+		return
+	}
+
+	if _, err = s.FindFavoriteByID(favorite1.ID); !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("FindFavoriteByID() error = %v, want %v", err, ErrFavoriteNotFound)
+	}
+	if _, err = s.PayFromFavorite(favorite1.ID); !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("PayFromFavorite() error = %v, want %v", err, ErrFavoriteNotFound)
+	}
+	if _, err = s.FindFavoriteByID(favorite2.ID); err != nil {
+		t.Errorf("FindFavoriteByID() error = %v", err)
+	}
+}
+
+func TestService_RenameFavorite(t *testing.T) {
+	s := &Service{
+		favorites: Favorites(),
+	}
+
+	err := s.RenameFavorite("nonExistingFavoriteID", "internet")
+	if !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("RenameFavorite() error = %v, want %v", err, ErrFavoriteNotFound)
+	}
+
+	err = s.RenameFavorite(defaultFavorite.ID, " ")
+	if !errors.Is(err, ErrInvalidFavoriteName) {
+		t.Errorf("RenameFavorite() error = %v, want %v", err, ErrInvalidFavoriteName)
+	}
+
+	err = s.RenameFavorite(defaultFavorite.ID, "internet")
+	if err != nil {
+		t.Errorf("RenameFavorite() error = %v", err)
+		return
+	}
+	favorite, _ := s.FindFavoriteByID(defaultFavorite.ID)
+	if favorite.Name != "internet" {
+		t.Errorf("RenameFavorite() name = %v, want %v", favorite.Name, "internet")
+	}
+}
+
+func TestService_UpdateFavoriteAmount(t *testing.T) {
+	s := &Service{
+		favorites: Favorites(),
+	}
+
+	err := s.UpdateFavoriteAmount("nonExistingFavoriteID", 20)
+	if !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("UpdateFavoriteAmount() error = %v, want %v", err, ErrFavoriteNotFound)
+	}
+
+	err = s.UpdateFavoriteAmount(defaultFavorite.ID, 0)
+	if !errors.Is(err, ErrAmountMustBePositive) {
+		t.Errorf("UpdateFavoriteAmount() error = %v, want %v", err, ErrAmountMustBePositive)
+	}
+
+	err = s.UpdateFavoriteAmount(defaultFavorite.ID, 20)
+	if err != nil {
+		t.Errorf("UpdateFavoriteAmount() error = %v", err)
+		return
+	}
+	favorite, _ := s.FindFavoriteByID(defaultFavorite.ID)
+	if favorite.Amount != 20 {
+		t.Errorf("UpdateFavoriteAmount() amount = %v, want %v", favorite.Amount, 20)
+	}
+}
+
+func TestService_FavoritesByAccount(t *testing.T) {
+	s := newTestService()
+	account1, _ := s.AddAccountWithBalance("9127660305", 100)
+	account2, _ := s.AddAccountWithBalance("9127660306", 100)
+	payment1, _ := s.Pay(account1.ID, 10, types.CategoryIt)
+	payment2, _ := s.Pay(account2.ID, 10, types.CategoryIt)
+	favorite1, _ := s.FavoritePayment(payment1.ID, types.CategoryIt)
+	_, _ = s.FavoritePayment(payment2.ID, types.CategoryIt)
+	favorite3, _ := s.FavoritePayment(payment1.ID, types.CategoryFood)
+
+	_, err := s.FavoritesByAccount(10)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("FavoritesByAccount() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	got, err := s.FavoritesByAccount(account1.ID)
+	if err != nil {
+		t.Errorf("FavoritesByAccount() error = %v", err)
+		return
+	}
+	want := []*types.Favorite{favorite1, favorite3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FavoritesByAccount() got = %v, want %v", got, want)
+	}
+}
+
+func TestService_ExportAccountsCSV_ImportAccountsCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.csv")
+
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := newTestService()
+	s.now = func() time.Time { return now }
+	_, _ = s.AddAccountWithBalance("9127660305", 10)
+	s.addAccount(&types.Account{ID: 2, Phone: "+992 \"91\", 2766", Balance: 11, CreatedAt: now})
+
+	err := s.ExportAccountsCSV(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	content, _ := ioutil.ReadFile(path)
+	want := "id,phone,balance\n1,9127660305,10\n2,\"+992 \"\"91\"\", 2766\",11\n"
+	if string(content) != want {
+		t.Errorf("ExportAccountsCSV() content = %q, want %q", content, want)
+	}
+
+	i := newTestService()
+	i.now = s.now
+	err = i.ImportAccountsCSV(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(s.accounts, i.accounts) {
+		t.Error(errors.New("imported and exported accounts doesn't match"))
+	}
+}
+
+func TestService_ImportAccountsCSV_corrupted(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "missing field", content: "id,phone,balance\n1,9127660305\n"},
+		{name: "malformed balance", content: "id,phone,balance\n1,9127660305,1x\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "accounts.csv")
+			_ = ioutil.WriteFile(path, []byte(tt.content), 0644)
+
+			s := newTestService()
+			err := s.ImportAccountsCSV(path)
+			if !errors.Is(err, ErrCorruptedExport) {
+				t.Errorf("ImportAccountsCSV() error = %v, want %v", err, ErrCorruptedExport)
+			}
+			if len(s.accounts) != 0 {
+				t.Errorf("ImportAccountsCSV() loaded accounts = %v", s.accounts)
+			}
+		})
+	}
+}
+
+func TestService_ExportToFileContext_cancelled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+
+	s := newTestService()
+	_, _ = s.AddAccountWithBalance("9127660305", 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := s.ExportToFileContext(ctx, path)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ExportToFileContext() error = %v, want %v", err, context.Canceled)
+	}
+
+	_, err = os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Errorf("ExportToFileContext() left partial file, stat error = %v", err)
+	}
+}
+
+func TestService_Accounts(t *testing.T) {
+	s := newTestService()
+	_, _ = s.RegisterAccount("9127660305")
+	_, _ = s.RegisterAccount("9127660306")
+
+	got := s.Accounts()
+	if !reflect.DeepEqual(got, s.accounts) {
+		t.Errorf("Accounts() got = %v, want %v", got, s.accounts)
+	}
+
+	got[0] = nil
+	_ = append(got[:1], &types.Account{ID: 10})
+	if s.accounts[0] == nil || s.accounts[1].ID != 2 {
+		t.Errorf("Accounts() exposed internal slice, accounts = %v", s.accounts)
+	}
+}
+
+func TestService_Payments_Favorites(t *testing.T) {
+	s := &Service{payments: Payments(), favorites: Favorites()}
+
+	payments := s.Payments()
+	if !reflect.DeepEqual(payments, s.payments) {
+		t.Errorf("Payments() got = %v, want %v", payments, s.payments)
+	}
+	payments[0] = nil
+	if s.payments[0] == nil {
+		t.Errorf("Payments() exposed internal slice, payments = %v", s.payments)
+	}
+
+	favorites := s.Favorites()
+	if !reflect.DeepEqual(favorites, s.favorites) {
+		t.Errorf("Favorites() got = %v, want %v", favorites, s.favorites)
+	}
+	favorites[0] = nil
+	if s.favorites[0] == nil {
+		t.Errorf("Favorites() exposed internal slice, favorites = %v", s.favorites)
+	}
+}
+
+func TestService_ConfirmPayment(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment1, _ := s.Pay(account.ID, 10, types.CategoryIt)
+	payment2, _ := s.Pay(account.ID, 10, types.CategoryIt)
+	_ = s.Reject(payment2.ID)
+
+	err := s.ConfirmPayment("nonExistingPaymentID")
+	if !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("ConfirmPayment() error = %v, want %v", err, ErrPaymentNotFound)
+	}
+
+	err = s.ConfirmPayment(payment2.ID)
+	if !errors.Is(err, ErrPaymentAlreadyRejected) {
+		t.Errorf("ConfirmPayment() error = %v, want %v", err, ErrPaymentAlreadyRejected)
+	}
+
+	err = s.ConfirmPayment(payment1.ID)
+	if err != nil {
+		t.Errorf("ConfirmPayment() error = %v", err)
+	}
+	if payment1.Status != types.PaymentStatusOK {
+		t.Errorf("ConfirmPayment() status = %v, want %v", payment1.Status, types.PaymentStatusOK)
+	}
+	if account.Balance != 90 {
+		t.Errorf("ConfirmPayment() balance = %v, want %v", account.Balance, 90)
+	}
+}
+
+func TestService_Pay_dailyLimit(t *testing.T) {
+	now := time.Date(2020, 11, 20, 23, 0, 0, 0, time.UTC)
+	s := newTestService()
+	s.now = func() time.Time { return now }
+	account, _ := s.AddAccountWithBalance("9127660305", 1_000)
+
+	err := s.SetDailyLimit(10, 100)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("SetDailyLimit() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	err = s.SetDailyLimit(account.ID, 100)
+	if err != nil {
+		t.Errorf("SetDailyLimit() error = %v", err)
+		return
+	}
+
+	payment, err := s.Pay(account.ID, 60, types.CategoryIt)
+	if err != nil {
+		t.Errorf("Pay() error = %v", err)
+		return
+	}
+	if !payment.CreatedAt.Equal(now) {
+		t.Errorf("Pay() CreatedAt = %v, want %v", payment.CreatedAt, now)
+	}
+
+	_, err = s.Pay(account.ID, 41, types.CategoryIt)
+	if !errors.Is(err, ErrDailyLimitExceeded) {
+		t.Errorf("Pay() error = %v, want %v", err, ErrDailyLimitExceeded)
+	}
+	if account.Balance != 940 {
+		t.Errorf("Pay() balance = %v, want %v", account.Balance, 940)
+	}
+
+	_ = s.Reject(payment.ID)
+	_, err = s.Pay(account.ID, 100, types.CategoryIt)
+	if err != nil {
+		t.Errorf("Pay() error = %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	_, err = s.Pay(account.ID, 100, types.CategoryIt)
+	if err != nil {
+		t.Errorf("Pay() error = %v", err)
+	}
+
+	_ = s.SetDailyLimit(account.ID, 0)
+	_, err = s.Pay(account.ID, 500, types.CategoryIt)
+	if err != nil {
+		t.Errorf("Pay() error = %v", err)
+	}
+}
+
+func TestService_Pay_createdAt(t *testing.T) {
+	now := time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC)
+	s := newTestService()
+	s.now = func() time.Time { return now }
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account.ID, 10, types.CategoryIt)
+
+	now = now.Add(time.Minute)
+	repeated, err := s.Repeat(payment.ID)
+	if err != nil {
+		t.Errorf("Repeat() error = %v", err)
+		return
+	}
+	if !repeated.CreatedAt.Equal(now) {
+		t.Errorf("Repeat() CreatedAt = %v, want %v", repeated.CreatedAt, now)
+	}
+
+	path := filepath.Join(t.TempDir(), "wallet.json")
+	_ = s.ExportToJSON(path)
+	i := newTestService()
+	_ = i.ImportFromJSON(path)
+
+	got, err := i.FindPaymentByID(payment.ID)
+	if err != nil {
+		t.Errorf("FindPaymentByID() error = %v", err)
+		return
+	}
+	if !got.CreatedAt.Equal(payment.CreatedAt) {
+		t.Errorf("FindPaymentByID() CreatedAt = %v, want %v", got.CreatedAt, payment.CreatedAt)
+	}
+}
+
+func TestService_RegisterAccountWithCurrency(t *testing.T) {
+	s := newTestService()
+	s.BaseCurrency = "TJS"
+
+	account1, _ := s.RegisterAccount("9127660305")
+	if account1.Currency != "TJS" {
+		t.Errorf("RegisterAccount() currency = %v, want %v", account1.Currency, "TJS")
+	}
+
+	account2, err := s.RegisterAccountWithCurrency("9127660306", "USD")
+	if err != nil {
+		t.Errorf("RegisterAccountWithCurrency() error = %v", err)
+		return
+	}
+	if account2.Currency != "USD" {
+		t.Errorf("RegisterAccountWithCurrency() currency = %v, want %v", account2.Currency, "USD")
+	}
+
+	_, err = s.RegisterAccountWithCurrency("9127660306", "TJS")
+	if !errors.Is(err, ErrPhoneRegistered) {
+		t.Errorf("RegisterAccountWithCurrency() error = %v, want %v", err, ErrPhoneRegistered)
+	}
+
+	account3, _ := s.RegisterAccountWithCurrency("9127660307", "USD")
+	_ = s.Deposit(account2.ID, 100)
+
+	_, err = s.Transfer(account2.ID, account1.ID, 10)
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Transfer() error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+	if account2.Balance != 100 || account1.Balance != 0 {
+		t.Errorf("Transfer() balances = %v, %v", account2.Balance, account1.Balance)
+	}
+
+	_, err = s.Transfer(account2.ID, account3.ID, 10)
+	if err != nil {
+		t.Errorf("Transfer() error = %v", err)
+	}
+}
+
+func TestService_FreezeAccount(t *testing.T) {
+	s := newTestService()
+	account1, _ := s.AddAccountWithBalance("9127660305", 100)
+	account2, _ := s.AddAccountWithBalance("9127660306", 100)
+
+	err := s.FreezeAccount(10)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("FreezeAccount() error = %v, want %v", err, ErrAccountNotFound)
+	}
+	err = s.UnfreezeAccount(10)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("UnfreezeAccount() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	err = s.FreezeAccount(account1.ID)
+	if err != nil {
+		t.Errorf("FreezeAccount() error = %v", err)
+		return
+	}
+
+	if _, err = s.Pay(account1.ID, 10, types.CategoryIt); !errors.Is(err, ErrAccountFrozen) {
+		t.Errorf("Pay() error = %v, want %v", err, ErrAccountFrozen)
+	}
+	if err = s.Withdraw(account1.ID, 10); !errors.Is(err, ErrAccountFrozen) {
+		t.Errorf("Withdraw() error = %v, want %v", err, ErrAccountFrozen)
+	}
+	if _, err = s.Transfer(account1.ID, account2.ID, 10); !errors.Is(err, ErrAccountFrozen) {
+		t.Errorf("Transfer() error = %v, want %v", err, ErrAccountFrozen)
+	}
+	if _, err = s.Transfer(account2.ID, account1.ID, 10); err != nil {
+		t.Errorf("Transfer() error = %v", err)
+	}
+	if err = s.Deposit(account1.ID, 10); err != nil {
+		t.Errorf("Deposit() error = %v", err)
+	}
+	if account1.Balance != 120 {
+		t.Errorf("balance = %v, want %v", account1.Balance, 120)
+	}
+
+	err = s.UnfreezeAccount(account1.ID)
+	if err != nil {
+		t.Errorf("UnfreezeAccount() error = %v", err)
+		return
+	}
+	if _, err = s.Pay(account1.ID, 10, types.CategoryIt); err != nil {
+		t.Errorf("Pay() error = %v", err)
+	}
+}
+
+func TestService_History(t *testing.T) {
+	now := time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC)
+	s := newTestService()
+	s.now = func() time.Time { return now }
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	other, _ := s.AddAccountWithBalance("9127660306", 100)
+
+	payment1, _ := s.Pay(account.ID, 1, types.CategoryIt)
+	payment2, _ := s.Pay(account.ID, 2, types.CategoryIt)
+	now = now.Add(time.Hour)
+	payment3, _ := s.Pay(account.ID, 3, types.CategoryIt)
+	_, _ = s.Pay(other.ID, 4, types.CategoryIt)
+	now = now.Add(-2 * time.Hour)
+	payment5, _ := s.Pay(account.ID, 5, types.CategoryIt)
+
+	_, err := s.History(10)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("History() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	got, err := s.History(account.ID)
+	if err != nil {
+		t.Errorf("History() error = %v", err)
+		return
+	}
+	deposit := &types.Payment{
+		ID:        "transaction-1",
+		AccountID: account.ID,
+		Amount:    -100,
+		Category:  types.PaymentCategory(types.TransactionDeposit),
+		Status:    types.PaymentStatusOK,
+		CreatedAt: time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC),
+	}
+	want := []*types.Payment{payment3, payment1, payment2, deposit, payment5}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("History() got = %v, want %v", got, want)
+	}
+}
+
+func TestService_History_credits(t *testing.T) {
+	now := time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC)
+	s := newTestService()
+	s.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	other, _ := s.AddAccountWithBalance("9127660306", 100)
+	_, _ = s.Transfer(other.ID, account.ID, 30)
+	rejected, _ := s.Pay(account.ID, 20, types.CategoryFood)
+	reversal, _ := s.RejectWithRecord(rejected.ID)
+	refunded, _ := s.Pay(account.ID, 10, types.CategoryFood)
+	_ = s.PartialRefund(refunded.ID, 5)
+
+	got, _ := s.History(account.ID)
+	var balance types.Money
+	kinds := make([]types.PaymentCategory, 0)
+	for _, entry := range got {
+		balance -= entry.Amount + entry.Fee
+		if entry.Amount < 0 && entry != reversal {
+			kinds = append(kinds, entry.Category)
+		}
+	}
+	if balance != account.Balance {
+		t.Errorf("History() adds up to %v, want balance %v", balance, account.Balance)
+	}
+	want := []types.PaymentCategory{"refund", "transfer", "deposit"}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("History() credits = %v, want %v", kinds, want)
+	}
+}
+
+func TestService_PaymentsPage(t *testing.T) {
+	now := time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC)
+	s := newTestService()
+	s.now = func() time.Time { return now }
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+
+	var payments []*types.Payment
+	for i := 1; i <= 5; i++ {
+		now = now.Add(time.Minute)
+		payment, _ := s.Pay(account.ID, types.Money(i), types.CategoryIt)
+		payments = append([]*types.Payment{payment}, payments...)
+	}
+
+	tests := []struct {
+		name      string
+		accountID int64
+		offset    int
+		limit     int
+		want      []*types.Payment
+		wantErr   error
+	}{
+		{name: "negative offset", accountID: account.ID, offset: -1, limit: 2, wantErr: ErrInvalidPagination},
+		{name: "negative limit", accountID: account.ID, offset: 0, limit: -1, wantErr: ErrInvalidPagination},
+		{name: "account not found", accountID: 10, offset: 0, limit: 2, wantErr: ErrAccountNotFound},
+		{name: "first page", accountID: account.ID, offset: 0, limit: 2, want: payments[:2]},
+		{name: "last page", accountID: account.ID, offset: 4, limit: 2, want: payments[4:]},
+		{name: "offset past the end", accountID: account.ID, offset: 5, limit: 2, want: []*types.Payment{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.PaymentsPage(tt.accountID, tt.offset, tt.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("PaymentsPage() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PaymentsPage() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_ExportToFileGzip_ImportFromFileGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.txt.gz")
+
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	_, _ = s.Pay(account.ID, 10, types.CategoryIt)
+	_, _ = s.AddAccountWithBalance("9127660306", 11)
+
+	err := s.ExportToFileGzip(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Errorf("export is not a valid gzip file: %v", err)
+		return
+	}
+	if _, err = ioutil.ReadAll(reader); err != nil {
+		t.Errorf("export is not a valid gzip file: %v", err)
+	}
+
+	i := newTestService()
+	err = i.ImportFromFileGzip(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(s.accounts, i.accounts) {
+		t.Error(errors.New("imported and exported accounts doesn't match"))
+	}
+	if !reflect.DeepEqual(s.payments, i.payments) {
+		t.Error(errors.New("imported and exported payments doesn't match"))
+	}
+}
+
+func TestService_Pay_wrappedError(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 10)
+
+	_, err := s.Pay(account.ID, 25, types.CategoryIt)
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Pay() error = %v, want %v", err, ErrNotEnoughBalance)
+		return
+	}
+	want := "pay: account 1 short by 15: not enough balance in account"
+	if err.Error() != want {
+		t.Errorf("Pay() error = %q, want %q", err.Error(), want)
+	}
+
+	_, err = s.Pay(10, 25, types.CategoryIt)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("Pay() error = %v, want %v", err, ErrAccountNotFound)
+		return
+	}
+	want = "pay: account 10: account not found"
+	if err.Error() != want {
+		t.Errorf("Pay() error = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestNewService(t *testing.T) {
+	now := time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC)
+	s := NewService(
+		WithStartID(100),
+		WithMaxBalance(50),
+		WithBaseCurrency("TJS"),
+		WithIDGenerator(func() string { return "payment-1" }),
+		WithClock(func() time.Time { return now }),
+	)
+
+	account, err := s.RegisterAccount("9127660305")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := &types.Account{ID: 100, Phone: "9127660305", Currency: "TJS", CreatedAt: now}
+	if !reflect.DeepEqual(account, want) {
+		t.Errorf("RegisterAccount() got = %v, want %v", account, want)
+	}
+
+	if err = s.Deposit(account.ID, 51); !errors.Is(err, ErrBalanceLimitExceeded) {
+		t.Errorf("Deposit() error = %v, want %v", err, ErrBalanceLimitExceeded)
+	}
+
+	_ = s.Deposit(account.ID, 50)
+	payment, err := s.Pay(account.ID, 10, types.CategoryIt)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if payment.ID != "payment-1" || !payment.CreatedAt.Equal(now) {
+		t.Errorf("Pay() got = %v", payment)
+	}
+}
+
+func TestNewService_zeroValue(t *testing.T) {
+	var s Service
+	account, err := s.RegisterAccount("9127660305")
+	if err != nil || account.ID != 1 {
+		t.Errorf("RegisterAccount() got = %v, %v", account, err)
+	}
+}
+
+func TestService_SetIDGenerator(t *testing.T) {
+	counter := 0
+	s := newTestService()
+	s.SetIDGenerator(func() string {
+		counter++
+		return "id-" + strconv.Itoa(counter)
+	})
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+
+	payment, _ := s.Pay(account.ID, 10, types.CategoryIt)
+	if payment.ID != "id-1" {
+		t.Errorf("Pay() ID = %v, want %v", payment.ID, "id-1")
+	}
+
+	favorite, _ := s.FavoritePayment(payment.ID, types.CategoryIt)
+	if favorite.ID != "id-2" {
+		t.Errorf("FavoritePayment() ID = %v, want %v", favorite.ID, "id-2")
+	}
+
+	repeated, _ := s.Repeat(payment.ID)
+	if repeated.ID != "id-3" {
+		t.Errorf("Repeat() ID = %v, want %v", repeated.ID, "id-3")
+	}
+
+	s.SetIDGenerator(nil)
+	payment, _ = s.Pay(account.ID, 10, types.CategoryIt)
+	if _, err := uuid.Parse(payment.ID); err != nil {
+		t.Errorf("Pay() ID = %v, want uuid", payment.ID)
+	}
+}
+
+func TestService_BalanceOf(t *testing.T) {
+	tests := []struct {
+		name      string
+		accountID int64
+		want      types.Money
+		wantErr   error
+	}{
+		{name: "found", accountID: 3, want: 2},
+		{name: "not found", accountID: 10, wantErr: ErrAccountNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{
+				accounts: Accounts(),
+			}
+			s.reindex()
+			got, err := s.BalanceOf(tt.accountID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("BalanceOf() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("BalanceOf() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_CountByStatus(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment1, _ := s.Pay(account.ID, 10, types.CategoryIt)
+	payment2, _ := s.Pay(account.ID, 10, types.CategoryIt)
+	_, _ = s.Pay(account.ID, 10, types.CategoryIt)
+	_, _ = s.Pay(account.ID, 10, types.CategoryIt)
+	_ = s.Reject(payment1.ID)
+	_ = s.ConfirmPayment(payment2.ID)
+
+	got := s.CountByStatus()
+	want := map[types.PaymentStatus]int{
+		types.PaymentStatusFail:       1,
+		types.PaymentStatusOK:         1,
+		types.PaymentStatusInProgress: 2,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CountByStatus() got = %v, want %v", got, want)
+	}
+}
+
+func TestService_RestoreFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account.ID, 10, types.CategoryIt)
+	_, _ = s.AddAccountWithBalance("9127660306", 11)
+	_ = s.ExportToFile(path)
+
+	i := newTestService()
+	_, _ = i.AddAccountWithBalance("9127660399", 1)
+	_, _ = i.AddAccountWithBalance("9127660398", 2)
+	_, _ = i.AddAccountWithBalance("9127660397", 3)
+	_ = i.SetDailyLimit(1, 100)
+
+	for n := 0; n < 2; n++ {
+		err := i.RestoreFromFile(path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(s.accounts, i.accounts) {
+		t.Errorf("RestoreFromFile() accounts = %v, want %v", i.accounts, s.accounts)
+	}
+	if i.nextAccountID != 2 {
+		t.Errorf("RestoreFromFile() nextAccountID = %v, want %v", i.nextAccountID, 2)
+	}
+	if len(i.dailyLimits) != 0 {
+		t.Errorf("RestoreFromFile() dailyLimits = %v, want empty", i.dailyLimits)
+	}
+	if _, err := i.FindPaymentByID(payment.ID); err != nil {
+		t.Errorf("FindPaymentByID() error = %v", err)
+	}
+
+	err := i.RestoreFromFile(filepath.Join(t.TempDir(), "missing.txt"))
+	if err == nil {
+		t.Errorf("RestoreFromFile() error = nil for missing file")
+	}
+	if len(i.accounts) != 2 {
+		t.Errorf("RestoreFromFile() changed state on failure, accounts = %v", i.accounts)
+	}
+}
+
+func TestService_MergeFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+
+	s := newTestService()
+	_, _ = s.AddAccountWithBalance("9127660305", 100)
+	account2, _ := s.AddAccountWithBalance("9127660306", 50)
+	payment, _ := s.Pay(account2.ID, 10, types.CategoryIt)
+	favorite, _ := s.FavoritePayment(payment.ID, types.CategoryIt)
+	_ = s.ExportToFile(path)
+
+	i := newTestService()
+	_, _ = i.AddAccountWithBalance("9127660305", 1)
+	_, _ = i.AddAccountWithBalance("9127660307", 2)
+
+	merged, skipped, err := i.MergeFromFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if merged != 1 || skipped != 1 {
+		t.Errorf("MergeFromFile() merged = %v, skipped = %v, want 1, 1", merged, skipped)
+	}
+
+	account, err := i.FindAccountByPhone("9127660306")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.ID != 3 || account.Balance != 40 {
+		t.Errorf("MergeFromFile() account = %v", account)
+	}
+	if i.nextAccountID != 3 {
+		t.Errorf("MergeFromFile() nextAccountID = %v, want %v", i.nextAccountID, 3)
+	}
+
+	existing, _ := i.FindAccountByPhone("9127660305")
+	if existing.ID != 1 || existing.Balance != 1 {
+		t.Errorf("MergeFromFile() changed existing account = %v", existing)
+	}
+
+	got, err := i.FindPaymentByID(payment.ID)
+	if err != nil || got.AccountID != account.ID {
+		t.Errorf("FindPaymentByID() got = %v, %v", got, err)
+	}
+	gotFavorite, err := i.FindFavoriteByID(favorite.ID)
+	if err != nil || gotFavorite.AccountID != account.ID {
+		t.Errorf("FindFavoriteByID() got = %v, %v", gotFavorite, err)
+	}
+}
+
+func TestService_SearchPayments(t *testing.T) {
+	s := newTestService()
+	account1, _ := s.AddAccountWithBalance("9127660305", 10_000)
+	account2, _ := s.AddAccountWithBalance("9127660306", 10_000)
+	payment1, _ := s.Pay(account1.ID, 500, types.CategoryFood)
+	payment2, _ := s.Pay(account1.ID, 1_500, types.CategoryFood)
+	payment3, _ := s.Pay(account2.ID, 2_000, types.CategoryFood)
+	payment4, _ := s.Pay(account2.ID, 3_000, types.CategoryIt)
+	_ = s.Reject(payment2.ID)
+	_ = s.Reject(payment3.ID)
+
+	tests := []struct {
+		name   string
+		filter PaymentFilter
+		want   []*types.Payment
+	}{
+		{
+			name:   "no filter",
+			filter: PaymentFilter{},
+			want:   []*types.Payment{payment1, payment2, payment3, payment4},
+		},
+		{
+			name:   "failed food payments over 1000",
+			filter: PaymentFilter{MinAmount: 1_000, Category: types.CategoryFood, Status: types.PaymentStatusFail},
+			want:   []*types.Payment{payment2, payment3},
+		},
+		{
+			name:   "by account and max amount",
+			filter: PaymentFilter{AccountID: account1.ID, MaxAmount: 1_000},
+			want:   []*types.Payment{payment1},
+		},
+		{
+			name:   "nothing matches",
+			filter: PaymentFilter{Category: types.CategoryShop},
+			want:   []*types.Payment{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.SearchPayments(tt.filter); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SearchPayments() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_Pay_categoryLimit(t *testing.T) {
+	s := newTestService()
+	account1, _ := s.AddAccountWithBalance("9127660305", 10_000)
+	account2, _ := s.AddAccountWithBalance("9127660306", 10_000)
+	s.SetCategoryLimit("entertainment", 5_000)
+
+	payment, err := s.Pay(account1.ID, 3_000, "entertainment")
+	if err != nil {
+		t.Errorf("Pay() error = %v", err)
+		return
+	}
+
+	_, err = s.Pay(account1.ID, 2_001, "entertainment")
+	if !errors.Is(err, ErrCategoryLimitExceeded) {
+		t.Errorf("Pay() error = %v, want %v", err, ErrCategoryLimitExceeded)
+	}
+	if account1.Balance != 7_000 {
+		t.Errorf("Pay() balance = %v, want %v", account1.Balance, 7_000)
+	}
+
+	if _, err = s.Pay(account1.ID, 2_001, types.CategoryFood); err != nil {
+		t.Errorf("Pay() error = %v", err)
+	}
+	if _, err = s.Pay(account2.ID, 5_000, "entertainment"); err != nil {
+		t.Errorf("Pay() error = %v", err)
+	}
+
+	_ = s.Reject(payment.ID)
+	if _, err = s.Pay(account1.ID, 5_000, "entertainment"); err != nil {
+		t.Errorf("Pay() error = %v", err)
+	}
+
+	s.SetCategoryLimit("entertainment", 0)
+	if _, err = s.Pay(account1.ID, 1, "entertainment"); err != nil {
+		t.Errorf("Pay() error = %v", err)
+	}
+}
+
+func TestService_RejectWithRecord(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account.ID, 30, types.CategoryIt)
+	_ = s.PartialRefund(payment.ID, 10)
+
+	_, err := s.RejectWithRecord("nonExistingPaymentID")
+	if !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("RejectWithRecord() error = %v, want %v", err, ErrPaymentNotFound)
+	}
+
+	reversal, err := s.RejectWithRecord(payment.ID)
+	if err != nil {
+		t.Errorf("RejectWithRecord() error = %v", err)
+		return
+	}
+	if reversal.Amount != -20 || reversal.AccountID != account.ID || reversal.Category != types.CategoryIt {
+		t.Errorf("RejectWithRecord() reversal = %v", reversal)
+	}
+	if payment.Status != types.PaymentStatusInProgress || payment.Amount != 30 {
+		t.Errorf("RejectWithRecord() changed original = %v", payment)
+	}
+	if account.Balance != 100 {
+		t.Errorf("RejectWithRecord() balance = %v, want %v", account.Balance, 100)
+	}
+	if _, err = s.FindPaymentByID(reversal.ID); err != nil {
+		t.Errorf("FindPaymentByID() error = %v", err)
+	}
+
+	if _, err = s.RejectWithRecord(payment.ID); !errors.Is(err, ErrPaymentAlreadyRejected) {
+		t.Errorf("RejectWithRecord() error = %v, want %v", err, ErrPaymentAlreadyRejected)
+	}
+	if err = s.Reject(payment.ID); !errors.Is(err, ErrPaymentAlreadyRejected) {
+		t.Errorf("Reject() error = %v, want %v", err, ErrPaymentAlreadyRejected)
+	}
+	if err = s.Reject(reversal.ID); !errors.Is(err, ErrReversalPayment) {
+		t.Errorf("Reject() error = %v, want %v", err, ErrReversalPayment)
+	}
+	if account.Balance != 100 {
+		t.Errorf("balance = %v, want %v", account.Balance, 100)
+	}
+}
+
+func TestService_AddFavorite(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+
+	tests := []struct {
+		name      string
+		accountID int64
+		favorite  string
+		amount    types.Money
+		wantErr   error
+	}{
+		{name: "account not found", accountID: 10, favorite: "internet", amount: 10, wantErr: ErrAccountNotFound},
+		{name: "amount must be greater than zero", accountID: account.ID, favorite: "internet", amount: 0, wantErr: ErrAmountMustBePositive},
+		{name: "empty name", accountID: account.ID, favorite: "", amount: 10, wantErr: ErrInvalidFavoriteName},
+		{name: "success", accountID: account.ID, favorite: "internet", amount: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.AddFavorite(tt.accountID, tt.favorite, tt.amount, types.CategoryIt)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("AddFavorite() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			found, err := s.FindFavoriteByID(got.ID)
+			if err != nil || !reflect.DeepEqual(found, got) {
+				t.Errorf("FindFavoriteByID() got = %v, %v", found, err)
+			}
+		})
+	}
+
+	if len(s.payments) != 0 {
+		t.Errorf("AddFavorite() created payments = %v", s.payments)
+	}
+}
+
+func TestService_AuditLog(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := newTestService()
+	s.now = func() time.Time { return now }
+
+	first, _ := s.RegisterAccount("9127660305")
+	second, _ := s.RegisterAccount("9127660306")
+	_ = s.Deposit(first.ID, 100)
+	payment, _ := s.Pay(first.ID, 30, types.CategoryFood)
+	_ = s.Reject(payment.ID)
+	_, _ = s.Transfer(first.ID, second.ID, 50)
+	_, _ = s.Transfer(first.ID, second.ID, 500)
+
+	want := []AuditEntry{
+		{Operation: OperationRegister, AccountID: first.ID, Time: now},
+		{Operation: OperationRegister, AccountID: second.ID, Time: now},
+		{Operation: OperationDeposit, AccountID: first.ID, Amount: 100, Time: now},
+		{Operation: OperationPay, AccountID: first.ID, Amount: 30, Time: now},
+		{Operation: OperationReject, AccountID: first.ID, Amount: 30, Time: now},
+		{Operation: OperationTransfer, AccountID: first.ID, Amount: 50, Time: now},
+	}
+	got := s.AuditLog()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AuditLog() got = %v, want %v", got, want)
+	}
+
+	got[0].Amount = 1000
+	if s.AuditLog()[0].Amount != 0 {
+		t.Error("AuditLog() returned the internal slice")
+	}
+
+	s.ClearAuditLog()
+	if got := s.AuditLog(); len(got) != 0 {
+		t.Errorf("ClearAuditLog() left entries = %v", got)
+	}
+}
+
+func TestService_DepositIdempotent(t *testing.T) {
+	s := newTestService()
+	account, _ := s.RegisterAccount("9127660305")
+
+	err := s.DepositIdempotent(account.ID, 100, "webhook-1")
+	if err != nil {
+		t.Errorf("DepositIdempotent() error = %v", err)
+		return
+	}
+	err = s.DepositIdempotent(account.ID, 100, "webhook-1")
+	if err != nil {
+		t.Errorf("DepositIdempotent() repeated error = %v", err)
+		return
+	}
+	if account.Balance != 100 {
+		t.Errorf("DepositIdempotent() balance = %v, want %v", account.Balance, 100)
+	}
+
+	err = s.DepositIdempotent(account.ID, 0, "webhook-2")
+	if !errors.Is(err, ErrAmountMustBePositive) {
+		t.Errorf("DepositIdempotent() error = %v, want %v", err, ErrAmountMustBePositive)
+	}
+	for _, key := range []string{"web|hook", " webhook-2", "webhook-2 "} {
+		err = s.DepositIdempotent(account.ID, 10, key)
+		if !errors.Is(err, ErrInvalidIdempotencyKey) {
+			t.Errorf("DepositIdempotent(%q) error = %v, want %v", key, err, ErrInvalidIdempotencyKey)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "wallet.txt")
+	err = s.ExportToFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	err = imported.ImportFromFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_ = imported.DepositIdempotent(account.ID, 100, "webhook-1")
+	_ = imported.DepositIdempotent(account.ID, 50, "webhook-2")
+	got, _ := imported.BalanceOf(account.ID)
+	if got != 150 {
+		t.Errorf("DepositIdempotent() after import balance = %v, want %v", got, 150)
+	}
+}
+
+func TestService_CloseAccount(t *testing.T) {
+	s := newTestService()
+	source, _ := s.AddAccountWithBalance("9127660305", 100)
+	destination, _ := s.AddAccountWithBalance("9127660306", 10)
+
+	tests := []struct {
+		name          string
+		accountID     int64
+		destinationID int64
+		wantErr       error
+	}{
+		{name: "same account", accountID: source.ID, destinationID: source.ID, wantErr: ErrSameAccount},
+		{name: "source not found", accountID: 10, destinationID: destination.ID, wantErr: ErrAccountNotFound},
+		{name: "destination not found", accountID: source.ID, destinationID: 10, wantErr: ErrAccountNotFound},
+		{name: "success", accountID: source.ID, destinationID: destination.ID},
+		{name: "already closed", accountID: source.ID, destinationID: destination.ID, wantErr: ErrAccountClosed},
+		{name: "destination closed", accountID: destination.ID, destinationID: source.ID, wantErr: ErrAccountClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.CloseAccount(tt.accountID, tt.destinationID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CloseAccount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if source.Balance != 0 || !source.Frozen || !source.Closed {
+		t.Errorf("CloseAccount() source = %v", source)
+	}
+	if destination.Balance != 110 {
+		t.Errorf("CloseAccount() destination balance = %v, want %v", destination.Balance, 110)
+	}
+	if _, err := s.Pay(source.ID, 1, types.CategoryFood); !errors.Is(err, ErrAccountClosed) {
+		t.Errorf("Pay() after close error = %v, want %v", err, ErrAccountClosed)
+	}
+	if err := s.Deposit(source.ID, 10); !errors.Is(err, ErrAccountClosed) {
+		t.Errorf("Deposit() after close error = %v, want %v", err, ErrAccountClosed)
+	}
+	if err := s.UnfreezeAccount(source.ID); !errors.Is(err, ErrAccountClosed) {
+		t.Errorf("UnfreezeAccount() after close error = %v, want %v", err, ErrAccountClosed)
+	}
+	if _, err := s.Transfer(destination.ID, source.ID, 10); !errors.Is(err, ErrAccountClosed) {
+		t.Errorf("Transfer() to closed account error = %v, want %v", err, ErrAccountClosed)
+	}
+	if source.Balance != 0 || destination.Balance != 110 {
+		t.Errorf("balances after close = %v, %v, want 0 and 110", source.Balance, destination.Balance)
+	}
+
+	var buf bytes.Buffer
+	if err := s.ExportTo(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	if err := imported.ImportFrom(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := imported.Deposit(source.ID, 10); !errors.Is(err, ErrAccountClosed) {
+		t.Errorf("Deposit() after import error = %v, want %v", err, ErrAccountClosed)
+	}
+
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Error(err)
+		return
+	}
+	restored := newTestService()
+	if err := restored.Import(dir); err != nil {
+		t.Error(err)
+		return
+	}
+	if account, err := restored.FindAccountByID(source.ID); err != nil || !account.Closed {
+		t.Errorf("Import() account = %v, error = %v, want closed", account, err)
+	}
+}
+
+func TestService_CloseAccount_credits(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	destination, _ := s.RegisterAccount("9127660306")
+	other, _ := s.AddAccountWithBalance("9127660307", 70)
+	payment, _ := s.Pay(account.ID, 40, types.CategoryFood)
+	_ = s.CloseAccount(account.ID, destination.ID)
+
+	if err := s.Reject(payment.ID); !errors.Is(err, ErrAccountClosed) {
+		t.Errorf("Reject() error = %v, want %v", err, ErrAccountClosed)
+	}
+	if _, err := s.RejectWithRecord(payment.ID); !errors.Is(err, ErrAccountClosed) {
+		t.Errorf("RejectWithRecord() error = %v, want %v", err, ErrAccountClosed)
+	}
+	if err := s.PartialRefund(payment.ID, 10); !errors.Is(err, ErrAccountClosed) {
+		t.Errorf("PartialRefund() error = %v, want %v", err, ErrAccountClosed)
+	}
+	if err := s.MergeAccounts(account.ID, other.ID); !errors.Is(err, ErrAccountClosed) {
+		t.Errorf("MergeAccounts() into closed error = %v, want %v", err, ErrAccountClosed)
+	}
+	if err := s.MergeAccounts(other.ID, account.ID); !errors.Is(err, ErrAccountClosed) {
+		t.Errorf("MergeAccounts() closed error = %v, want %v", err, ErrAccountClosed)
+	}
+	if account.Balance != 0 || other.Balance != 70 || len(s.accounts) != 3 {
+		t.Errorf("balances after close = %v and %v, accounts = %v", account.Balance, other.Balance, len(s.accounts))
+	}
+}
+
+func TestService_BulkDeposit(t *testing.T) {
+	s := newTestService()
+	first, _ := s.RegisterAccount("9127660305")
+	second, _ := s.RegisterAccount("9127660306")
+
+	applied, err := s.BulkDeposit([]int64{first.ID, 10, second.ID}, 50)
+	if err != nil {
+		t.Errorf("BulkDeposit() error = %v", err)
+		return
+	}
+	if applied != 2 {
+		t.Errorf("BulkDeposit() applied = %v, want %v", applied, 2)
+	}
+	if first.Balance != 50 || second.Balance != 50 {
+		t.Errorf("BulkDeposit() balances = %v, %v", first.Balance, second.Balance)
+	}
+
+	applied, err = s.BulkDeposit([]int64{first.ID}, 0)
+	if !errors.Is(err, ErrAmountMustBePositive) || applied != 0 {
+		t.Errorf("BulkDeposit() = %v, %v, want %v", applied, err, ErrAmountMustBePositive)
+	}
+	if first.Balance != 50 {
+		t.Errorf("BulkDeposit() touched balance = %v", first.Balance)
+	}
+
+	s.MinDeposit = 20
+	applied, err = s.BulkDeposit([]int64{first.ID}, 10)
+	if !errors.Is(err, ErrDepositTooSmall) || applied != 0 {
+		t.Errorf("BulkDeposit() = %v, %v, want %v", applied, err, ErrDepositTooSmall)
+	}
+
+	s.MaxBalance = 120
+	transactions, audit := len(s.transactions), len(s.auditLog)
+	applied, err = s.BulkDeposit([]int64{first.ID, second.ID, first.ID}, 50)
+	if !errors.Is(err, ErrBalanceLimitExceeded) || applied != 0 {
+		t.Errorf("BulkDeposit() = %v, %v, want 0, %v", applied, err, ErrBalanceLimitExceeded)
+	}
+	if first.Balance != 50 || second.Balance != 50 {
+		t.Errorf("BulkDeposit() balances = %v, %v, want both rolled back to 50", first.Balance, second.Balance)
+	}
+	if len(s.transactions) != transactions || len(s.auditLog) != audit {
+		t.Errorf("BulkDeposit() transactions = %v, audit = %v, want %v and %v", len(s.transactions), len(s.auditLog), transactions, audit)
+	}
+}
+
+func TestService_AveragePayment(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 1000)
+	empty, _ := s.RegisterAccount("9127660306")
+
+	_, _ = s.Pay(account.ID, 100, types.CategoryFood)
+	_, _ = s.Pay(account.ID, 200, types.CategoryIt)
+	failed, _ := s.Pay(account.ID, 500, types.CategoryShop)
+	_ = s.Reject(failed.ID)
+	reversed, _ := s.Pay(account.ID, 400, types.CategoryShop)
+	_, _ = s.RejectWithRecord(reversed.ID)
+
+	tests := []struct {
+		name      string
+		accountID int64
+		want      types.Money
+		wantErr   error
+	}{
+		{name: "account not found", accountID: 10, wantErr: ErrAccountNotFound},
+		{name: "no payments", accountID: empty.ID, want: 0},
+		{name: "success", accountID: account.ID, want: 150},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.AveragePayment(tt.accountID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("AveragePayment() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("AveragePayment() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_TopSpender(t *testing.T) {
+	s := newTestService()
+	_, _, err := s.TopSpender()
+	if !errors.Is(err, ErrNoAccounts) {
+		t.Errorf("TopSpender() error = %v, want %v", err, ErrNoAccounts)
+	}
+
+	first, _ := s.AddAccountWithBalance("9127660305", 1000)
+	second, _ := s.AddAccountWithBalance("9127660306", 1000)
+	third, _ := s.AddAccountWithBalance("9127660307", 1000)
+	_, _ = s.Pay(first.ID, 100, types.CategoryFood)
+	_, _ = s.Pay(second.ID, 300, types.CategoryFood)
+	_, _ = s.Pay(third.ID, 200, types.CategoryFood)
+	_, _ = s.Pay(third.ID, 100, types.CategoryIt)
+	failed, _ := s.Pay(first.ID, 500, types.CategoryShop)
+	_ = s.Reject(failed.ID)
+
+	account, total, err := s.TopSpender()
+	if err != nil {
+		t.Errorf("TopSpender() error = %v", err)
+		return
+	}
+	if account != second || total != 300 {
+		t.Errorf("TopSpender() got = %v, %v, want %v, %v", account, total, second, 300)
+	}
+}
+
+func TestService_SchedulePayment_RunDue(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+
+	_, err := s.SchedulePayment(10, 10, types.CategoryFood, now)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("SchedulePayment() error = %v, want %v", err, ErrAccountNotFound)
+	}
+	_, err = s.SchedulePayment(account.ID, 0, types.CategoryFood, now)
+	if !errors.Is(err, ErrAmountMustBePositive) {
+		t.Errorf("SchedulePayment() error = %v, want %v", err, ErrAmountMustBePositive)
+	}
+
+	second, _ := s.SchedulePayment(account.ID, 60, types.CategoryIt, now.Add(-time.Hour))
+	_, _ = s.SchedulePayment(account.ID, 30, types.CategoryFood, now.Add(-2*time.Hour))
+	tooBig, _ := s.SchedulePayment(account.ID, 50, types.CategoryShop, now)
+	_, _ = s.SchedulePayment(account.ID, 5, types.CategoryShop, now.Add(time.Hour))
+
+	payments, err := s.RunDue(now)
+	if err != nil {
+		t.Errorf("RunDue() error = %v", err)
+		return
+	}
+	if len(payments) != 2 || payments[0].Amount != 30 || payments[1].Amount != 60 {
+		t.Errorf("RunDue() got = %v", payments)
+		return
+	}
+	if account.Balance != 10 {
+		t.Errorf("RunDue() balance = %v, want %v", account.Balance, 10)
+	}
+
+	for _, scheduled := range s.scheduled {
+		switch scheduled.ID {
+		case second:
+			if scheduled.Status != types.PaymentStatusOK || scheduled.PaymentID != payments[1].ID {
+				t.Errorf("RunDue() scheduled = %v", scheduled)
+			}
+		case tooBig:
+			if scheduled.Status != types.PaymentStatusFail {
+				t.Errorf("RunDue() scheduled = %v, want failed", scheduled)
+			}
+		}
+	}
+
+	payments, _ = s.RunDue(now)
+	if len(payments) != 0 {
+		t.Errorf("RunDue() repeated got = %v", payments)
+	}
+}
+
+func TestService_AddRecurring_TickRecurring(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := newTestService()
+	s.now = func() time.Time { return now }
+	account, _ := s.AddAccountWithBalance("9127660305", 25)
+
+	_, err := s.AddRecurring(account.ID, 10, types.CategoryIt, 0)
+	if !errors.Is(err, ErrInvalidInterval) {
+		t.Errorf("AddRecurring() error = %v, want %v", err, ErrInvalidInterval)
+	}
+	_, err = s.AddRecurring(10, 10, types.CategoryIt, time.Hour)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("AddRecurring() error = %v, want %v", err, ErrAccountNotFound)
+	}
+	_, err = s.AddRecurring(account.ID, 10, types.CategoryIt, time.Hour)
+	if err != nil {
+		t.Errorf("AddRecurring() error = %v", err)
+		return
+	}
+
+	s.TickRecurring(now.Add(30 * time.Minute))
+	if account.Balance != 25 {
+		t.Errorf("TickRecurring() charged early, balance = %v", account.Balance)
+	}
+
+	s.TickRecurring(now.Add(3 * time.Hour))
+	if account.Balance != 5 || len(s.payments) != 2 {
+		t.Errorf("TickRecurring() balance = %v, payments = %v", account.Balance, len(s.payments))
+	}
+
+	_ = s.Deposit(account.ID, 5)
+	s.TickRecurring(now.Add(3 * time.Hour))
+	if account.Balance != 0 || len(s.payments) != 3 {
+		t.Errorf("TickRecurring() retry balance = %v, payments = %v", account.Balance, len(s.payments))
+	}
+	if want := now.Add(4 * time.Hour); !s.recurring[0].NextRun.Equal(want) {
+		t.Errorf("TickRecurring() next run = %v, want %v", s.recurring[0].NextRun, want)
+	}
+}
+
+func TestService_Clone(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account.ID, 10, types.CategoryFood)
+	favorite, _ := s.FavoritePayment(payment.ID, "food")
+
+	clone := s.Clone()
+	if !reflect.DeepEqual(clone.accounts, s.accounts) || !reflect.DeepEqual(clone.payments, s.payments) ||
+		!reflect.DeepEqual(clone.favorites, s.favorites) || clone.nextAccountID != s.nextAccountID {
+		t.Errorf("Clone() got = %v, want %v", clone, s)
+		return
+	}
+
+	_ = clone.Deposit(account.ID, 50)
+	_ = clone.Reject(payment.ID)
+	_ = clone.RenameFavorite(favorite.ID, "groceries")
+	_, _ = clone.RegisterAccount("9127660306")
+
+	if account.Balance != 90 || payment.Status != types.PaymentStatusInProgress || favorite.Name != "food" {
+		t.Errorf("Clone() changes reached the original: %v, %v, %v", account, payment, favorite)
+	}
+	if len(s.accounts) != 1 || s.nextAccountID != 1 {
+		t.Errorf("Clone() shares accounts slice, accounts = %v", s.accounts)
+	}
+}
+
+func TestService_TransferFavorite(t *testing.T) {
+	s := newTestService()
+	source, _ := s.AddAccountWithBalance("9127660305", 100)
+	destination, _ := s.RegisterAccount("9127660306")
+	favorite, _ := s.AddFavorite(source.ID, "rent", 30, types.CategoryShop)
+
+	err := s.SetFavoriteDestination(favorite.ID, source.ID)
+	if !errors.Is(err, ErrSameAccount) {
+		t.Errorf("SetFavoriteDestination() error = %v, want %v", err, ErrSameAccount)
+	}
+	err = s.SetFavoriteDestination(favorite.ID, 10)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("SetFavoriteDestination() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	err = s.TransferFavorite(favorite.ID)
+	if err != nil {
+		t.Errorf("TransferFavorite() error = %v", err)
+		return
+	}
+	if source.Balance != 70 || len(s.payments) != 1 {
+		t.Errorf("TransferFavorite() without destination balance = %v, payments = %v", source.Balance, s.payments)
+	}
+
+	_ = s.SetFavoriteDestination(favorite.ID, destination.ID)
+	err = s.TransferFavorite(favorite.ID)
+	if err != nil {
+		t.Errorf("TransferFavorite() error = %v", err)
+		return
+	}
+	if source.Balance != 40 || destination.Balance != 30 || len(s.payments) != 1 {
+		t.Errorf("TransferFavorite() balances = %v, %v", source.Balance, destination.Balance)
+	}
+
+	_ = s.DeleteAccount(destination.ID)
+	err = s.TransferFavorite(favorite.ID)
+	if !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("TransferFavorite() error = %v, want %v", err, ErrFavoriteNotFound)
+	}
+	err = s.TransferFavorite("unknown")
+	if !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("TransferFavorite() error = %v, want %v", err, ErrFavoriteNotFound)
+	}
+}
+
+func TestService_RegisterAccount_maxAccounts(t *testing.T) {
+	s := NewService(WithMaxAccounts(2))
+	_, _ = s.RegisterAccount("9127660305")
+	_, _ = s.RegisterAccount("9127660306")
+
+	_, err := s.RegisterAccount("9127660307")
+	if !errors.Is(err, ErrAccountLimitReached) {
+		t.Errorf("RegisterAccount() error = %v, want %v", err, ErrAccountLimitReached)
+	}
+	if s.nextAccountID != 2 {
+		t.Errorf("RegisterAccount() nextAccountID = %v, want %v", s.nextAccountID, 2)
+	}
+
+	_ = s.DeleteAccount(1)
+	account, err := s.RegisterAccount("9127660307")
+	if err != nil || account.ID != 3 {
+		t.Errorf("RegisterAccount() got = %v, %v", account, err)
+	}
+}
+
+func TestService_RegisterAccount_normalizesPhone(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount("+992 900 00-00-00")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Phone != "+992900000000" {
+		t.Errorf("RegisterAccount() phone = %v, want %v", account.Phone, "+992900000000")
+	}
+
+	_, err = s.RegisterAccount("+992900000000")
+	if !errors.Is(err, ErrPhoneRegistered) {
+		t.Errorf("RegisterAccount() error = %v, want %v", err, ErrPhoneRegistered)
+	}
+
+	found, err := s.FindAccountByPhone("+992 (900) 000-000")
+	if err != nil || found != account {
+		t.Errorf("FindAccountByPhone() got = %v, %v, want %v", found, err, account)
+	}
+}
+
+func TestService_ExportStatement(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	ID := 0
+	s := newTestService()
+	s.now = func() time.Time { return now }
+	s.idFunc = func() string {
+		ID++
+		return "payment-" + strconv.Itoa(ID)
+	}
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	other, _ := s.AddAccountWithBalance("9127660306", 100)
+	_, _ = s.Pay(account.ID, 10, types.CategoryFood)
+	_, _ = s.Pay(other.ID, 20, types.CategoryIt)
+	failed, _ := s.Pay(account.ID, 30, types.CategoryShop)
+	_ = s.Reject(failed.ID)
+
+	path := filepath.Join(t.TempDir(), "statement.csv")
+	err := s.ExportStatement(10, path)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("ExportStatement() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	err = s.ExportStatement(account.ID, path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	content, _ := ioutil.ReadFile(path)
+	want := "account,1,9127660305,90,\n" +
+		"id,amount,category,status,created_at\n" +
+		"payment-1,10,food,INPROGRESS,2021-03-01T10:00:00Z\n" +
+		"payment-3,30,shop,FAIL,2021-03-01T10:00:00Z\n" +
+		"transaction,kind,amount,reference,created_at\n" +
+		"1,deposit,100,,2021-03-01T10:00:00Z\n" +
+		"3,payment,-10,payment-1,2021-03-01T10:00:00Z\n" +
+		"5,payment,-30,payment-3,2021-03-01T10:00:00Z\n" +
+		"6,refund,30,payment-3,2021-03-01T10:00:00Z\n"
+	if string(content) != want {
+		t.Errorf("ExportStatement() content = %q, want %q", content, want)
+	}
+}
+
+func TestService_RetryFailed(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	first, _ := s.Pay(account.ID, 60, types.CategoryFood)
+	second, _ := s.Pay(account.ID, 30, types.CategoryIt)
+	third, _ := s.Pay(account.ID, 10, types.CategoryShop)
+	_ = s.Reject(first.ID)
+	_ = s.Reject(second.ID)
+	_ = s.Reject(third.ID)
+	_ = s.Withdraw(account.ID, 55)
+
+	_, err := s.RetryFailed(10)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("RetryFailed() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	succeeded, err := s.RetryFailed(account.ID)
+	if err != nil {
+		t.Errorf("RetryFailed() error = %v", err)
+		return
+	}
+	if succeeded != 2 || account.Balance != 5 {
+		t.Errorf("RetryFailed() succeeded = %v, balance = %v", succeeded, account.Balance)
+	}
+	if first.Status != types.PaymentStatusFail || second.Status != types.PaymentStatusInProgress ||
+		third.Status != types.PaymentStatusInProgress || len(s.payments) != 3 {
+		t.Errorf("RetryFailed() payments = %v", s.payments)
+	}
+
+	succeeded, _ = s.RetryFailed(account.ID)
+	if succeeded != 0 || account.Balance != 5 {
+		t.Errorf("RetryFailed() repeated succeeded = %v, balance = %v", succeeded, account.Balance)
+	}
+}
+
+func TestService_SetOverdraftLimit(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 10)
+
+	err := s.SetOverdraftLimit(10, 50)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("SetOverdraftLimit() error = %v, want %v", err, ErrAccountNotFound)
+	}
+	_ = s.SetOverdraftLimit(account.ID, 50)
+
+	_, err = s.Pay(account.ID, 40, types.CategoryFood)
+	if err != nil {
+		t.Errorf("Pay() error = %v", err)
+	}
+	err = s.Withdraw(account.ID, 20)
+	if err != nil {
+		t.Errorf("Withdraw() error = %v", err)
+	}
+	if account.Balance != -50 {
+		t.Errorf("Pay() balance = %v, want %v", account.Balance, -50)
+	}
+	err = s.Withdraw(account.ID, 1)
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Withdraw() error = %v, want %v", err, ErrNotEnoughBalance)
+	}
+
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+	err = s.ExportToFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	err = imported.ImportFromFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(imported.accounts, s.accounts) {
+		t.Errorf("ImportFromFile() accounts = %v, want %v", imported.accounts, s.accounts)
+	}
+}
+
+func TestService_PaymentsByCategory(t *testing.T) {
+	s := newTestService()
+	first, _ := s.AddAccountWithBalance("9127660305", 100)
+	second, _ := s.AddAccountWithBalance("9127660306", 100)
+	food1, _ := s.Pay(first.ID, 10, types.CategoryFood)
+	_, _ = s.Pay(first.ID, 20, types.CategoryIt)
+	food2, _ := s.Pay(second.ID, 30, types.CategoryFood)
+
+	tests := []struct {
+		name     string
+		category types.PaymentCategory
+		want     []*types.Payment
+	}{
+		{name: "food", category: types.CategoryFood, want: []*types.Payment{food1, food2}},
+		{name: "unknown", category: "travel", want: []*types.Payment{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.PaymentsByCategory(tt.category)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PaymentsByCategory() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_CanPay(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	frozen, _ := s.AddAccountWithBalance("9127660306", 100)
+	_ = s.FreezeAccount(frozen.ID)
+	limited, _ := s.AddAccountWithBalance("9127660307", 100)
+	_ = s.SetDailyLimit(limited.ID, 50)
+
+	tests := []struct {
+		name      string
+		accountID int64
+		amount    types.Money
+		wantErr   error
+	}{
+		{name: "amount must be greater than zero", accountID: account.ID, amount: 0, wantErr: ErrAmountMustBePositive},
+		{name: "account not found", accountID: 10, amount: 10, wantErr: ErrAccountNotFound},
+		{name: "frozen", accountID: frozen.ID, amount: 10, wantErr: ErrAccountFrozen},
+		{name: "not enough balance", accountID: account.ID, amount: 101, wantErr: ErrNotEnoughBalance},
+		{name: "daily limit", accountID: limited.ID, amount: 60, wantErr: ErrDailyLimitExceeded},
+		{name: "success", accountID: account.ID, amount: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.CanPay(tt.accountID, tt.amount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CanPay() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if account.Balance != 100 || len(s.payments) != 0 {
+		t.Errorf("CanPay() changed state, balance = %v, payments = %v", account.Balance, s.payments)
+	}
+}
+
+func TestService_MergeAccounts(t *testing.T) {
+	s := newTestService()
+	keep, _ := s.AddAccountWithBalance("9127660305", 100)
+	merge, _ := s.AddAccountWithBalance("9127660306", 50)
+	payment, _ := s.Pay(merge.ID, 20, types.CategoryFood)
+	favorite, _ := s.FavoritePayment(payment.ID, "food")
+
+	tests := []struct {
+		name    string
+		keepID  int64
+		mergeID int64
+		wantErr error
+	}{
+		{name: "same account", keepID: keep.ID, mergeID: keep.ID, wantErr: ErrSameAccount},
+		{name: "keep not found", keepID: 10, mergeID: merge.ID, wantErr: ErrAccountNotFound},
+		{name: "merge not found", keepID: keep.ID, mergeID: 10, wantErr: ErrAccountNotFound},
+		{name: "success", keepID: keep.ID, mergeID: merge.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.MergeAccounts(tt.keepID, tt.mergeID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("MergeAccounts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if keep.Balance != 130 {
+		t.Errorf("MergeAccounts() balance = %v, want %v", keep.Balance, 130)
+	}
+	if payment.AccountID != keep.ID || favorite.AccountID != keep.ID {
+		t.Errorf("MergeAccounts() payment = %v, favorite = %v", payment, favorite)
+	}
+	if _, err := s.FindAccountByID(merge.ID); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("FindAccountByID() error = %v, want %v", err, ErrAccountNotFound)
+	}
+	if _, err := s.FindPaymentByID(payment.ID); err != nil {
+		t.Errorf("FindPaymentByID() error = %v", err)
+	}
+}
+
+func TestService_MergeAccounts_favorites(t *testing.T) {
+	s := newTestService()
+	keep, _ := s.AddAccountWithBalance("9127660305", 100)
+	merge, _ := s.AddAccountWithBalance("9127660306", 50)
+	other, _ := s.RegisterAccount("9127660307")
+	toMerge, _ := s.AddFavorite(keep.ID, "to merge", 10, types.CategoryShop)
+	_ = s.SetFavoriteDestination(toMerge.ID, merge.ID)
+	toKeep, _ := s.AddFavorite(merge.ID, "to keep", 10, types.CategoryShop)
+	_ = s.SetFavoriteDestination(toKeep.ID, keep.ID)
+	toOther, _ := s.AddFavorite(merge.ID, "to other", 10, types.CategoryShop)
+	_ = s.SetFavoriteDestination(toOther.ID, other.ID)
+
+	if err := s.MergeAccounts(keep.ID, merge.ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if want := []*types.Favorite{toOther}; !reflect.DeepEqual(s.favorites, want) {
+		t.Errorf("MergeAccounts() favorites = %v, want %v", s.favorites, want)
+	}
+	if err := s.TransferFavorite(toOther.ID); err != nil || other.Balance != 10 {
+		t.Errorf("TransferFavorite() error = %v, balance = %v, want nil and 10", err, other.Balance)
+	}
+}
+
+func TestService_AccountViews(t *testing.T) {
+	s := newTestService()
+	_, _ = s.AddAccountWithBalance("9127660305", 100)
+	_, _ = s.RegisterAccount("9127660306")
+
+	data, err := json.Marshal(s.AccountViews())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := `[{"id":1,"phone":"9127660305","balance":100},{"id":2,"phone":"9127660306","balance":0}]`
+	if string(data) != want {
+		t.Errorf("AccountViews() json = %s, want %s", data, want)
+	}
+}
+
+func TestService_Deposit_blockWhenFrozen(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   bool
+		wantErr error
+	}{
+		{name: "deposits allowed", block: false},
+		{name: "deposits blocked", block: true, wantErr: ErrAccountFrozen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewService(WithBlockDepositsWhenFrozen(tt.block))
+			account, _ := s.RegisterAccount("9127660305")
+			_ = s.FreezeAccount(account.ID)
+
+			err := s.Deposit(account.ID, 10)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Deposit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_AccountsBelow(t *testing.T) {
+	s := &Service{accounts: Accounts()}
+	s.reindex()
+
+	tests := []struct {
+		name      string
+		threshold types.Money
+		want      []*types.Account
+	}{
+		{name: "empty accounts", threshold: 1, want: s.accounts[:1]},
+		{name: "low balance", threshold: 3, want: s.accounts[:3]},
+		{name: "none", threshold: 0, want: []*types.Account{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.AccountsBelow(tt.threshold)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AccountsBelow() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_PayWithNote(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, err := s.PayWithNote(account.ID, 10, types.CategoryShop, "birthday gift; 100% | mom")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, _ = s.Pay(account.ID, 20, types.CategoryShop)
+
+	got := s.SearchPayments(PaymentFilter{Description: "birthday"})
+	if !reflect.DeepEqual(got, []*types.Payment{payment}) {
+		t.Errorf("SearchPayments() got = %v, want %v", got, payment)
+	}
+
+	path := filepath.Join(t.TempDir(), "wallet.txt")
+	err = s.ExportToFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	err = imported.ImportFromFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(imported.payments, s.payments) {
+		t.Errorf("ImportFromFile() payments = %v, want %v", imported.payments, s.payments)
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dumped := newTestService()
+	err = dumped.Import(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(dumped.payments, s.payments) {
+		t.Errorf("Import() payments = %v, want %v", dumped.payments, s.payments)
+	}
+}
+
+func TestService_Batch(t *testing.T) {
+	s := newTestService()
+	first, _ := s.AddAccountWithBalance("9127660305", 100)
+	second, _ := s.AddAccountWithBalance("9127660306", 100)
+	s.ClearAuditLog()
+
+	tests := []struct {
+		name    string
+		ops     []Operation
+		wantErr error
+		first   types.Money
+		second  types.Money
+	}{
+		{
+			name: "rolled back",
+			ops: []Operation{
+				{Kind: OperationDeposit, AccountID: first.ID, Amount: 50},
+				{Kind: OperationPay, AccountID: first.ID, Amount: 120, Category: types.CategoryFood},
+				{Kind: OperationTransfer, AccountID: first.ID, ToID: second.ID, Amount: 100},
+			},
+			wantErr: ErrNotEnoughBalance,
+			first:   100,
+			second:  100,
+		},
+		{
+			name: "unknown operation",
+			ops: []Operation{
+				{Kind: OperationPay, AccountID: first.ID, Amount: 10, Category: types.CategoryFood},
+				{Kind: "withdraw", AccountID: first.ID, Amount: 10},
+			},
+			wantErr: ErrUnknownOperation,
+			first:   100,
+			second:  100,
+		},
+		{
+			name: "applied",
+			ops: []Operation{
+				{Kind: OperationDeposit, AccountID: first.ID, Amount: 50},
+				{Kind: OperationPay, AccountID: first.ID, Amount: 120, Category: types.CategoryFood},
+				{Kind: OperationTransfer, AccountID: first.ID, ToID: second.ID, Amount: 30},
+			},
+			first:  0,
+			second: 130,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Batch(tt.ops)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Batch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if first.Balance != tt.first || second.Balance != tt.second {
+				t.Errorf("Batch() balances = %v, %v, want %v, %v", first.Balance, second.Balance, tt.first, tt.second)
+			}
+			if tt.wantErr != nil && (len(s.payments) != 0 || len(s.paymentsByID) != 0 || len(s.auditLog) != 0) {
+				t.Errorf("Batch() left payments = %v, audit = %v", s.payments, s.auditLog)
+			}
+		})
+	}
+
+	if len(s.payments) != 1 || len(s.auditLog) != 3 {
+		t.Errorf("Batch() payments = %v, audit = %v", s.payments, s.auditLog)
+	}
+}
+
+func TestService_AddTag_RemoveTag_AccountsByTag(t *testing.T) {
+	s := newTestService()
+	first, _ := s.RegisterAccount("9127660305")
+	second, _ := s.RegisterAccount("9127660306")
+
+	if err := s.AddTag(10, "vip"); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("AddTag() error = %v, want %v", err, ErrAccountNotFound)
+	}
+	for _, tag := range []string{"a,b", " vip", ""} {
+		if err := s.AddTag(first.ID, tag); !errors.Is(err, ErrInvalidTag) {
+			t.Errorf("AddTag(%q) error = %v, want %v", tag, err, ErrInvalidTag)
+		}
+	}
+	_ = s.AddTag(first.ID, "vip")
+	_ = s.AddTag(first.ID, "vip")
+	_ = s.AddTag(first.ID, "student")
+	_ = s.AddTag(second.ID, "vip")
+
+	if !reflect.DeepEqual(first.Tags, []string{"vip", "student"}) {
+		t.Errorf("AddTag() tags = %v", first.Tags)
+	}
+	if got := s.AccountsByTag("vip"); !reflect.DeepEqual(got, []*types.Account{first, second}) {
+		t.Errorf("AccountsByTag() got = %v", got)
+	}
+
+	_ = s.RemoveTag(second.ID, "vip")
+	if got := s.AccountsByTag("vip"); !reflect.DeepEqual(got, []*types.Account{first}) {
+		t.Errorf("AccountsByTag() after RemoveTag got = %v", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "wallet.json")
+	err := s.ExportToJSON(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	err = imported.ImportFromJSON(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(imported.accounts, s.accounts) {
+		t.Errorf("ImportFromJSON() accounts = %v, want %v", imported.accounts, s.accounts)
+	}
+}
+
+func TestService_Pay_fee(t *testing.T) {
+	s := NewService(WithFeeRate(0.07))
+	account, _ := s.RegisterAccount("9127660305")
+	_ = s.Deposit(account.ID, 110)
+
+	payment, err := s.Pay(account.ID, 100, types.CategoryFood)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if payment.Fee != 7 || account.Balance != 3 {
+		t.Errorf("Pay() fee = %v, balance = %v", payment.Fee, account.Balance)
+	}
+
+	_, err = s.Pay(account.ID, 3, types.CategoryFood)
+	if !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("Pay() error = %v, want %v", err, ErrNotEnoughBalance)
+	}
+
+	_ = s.Reject(payment.ID)
+	if account.Balance != 110 {
+		t.Errorf("Reject() balance = %v, want %v", account.Balance, 110)
+	}
+
+	s.FeeRate = 0.015
+	payment, _ = s.Pay(account.ID, 100, types.CategoryFood)
+	if payment.Fee != 2 {
+		t.Errorf("Pay() fee = %v, want %v", payment.Fee, 2)
+	}
+}
+
+func TestService_PayWithID(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+
+	ID := s.ReservePaymentID()
+	if _, err := uuid.Parse(ID); err != nil {
+		t.Errorf("ReservePaymentID() got = %v, %v", ID, err)
+	}
+
+	payment, err := s.PayWithID(ID, account.ID, 10, types.CategoryFood)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if payment.ID != ID {
+		t.Errorf("PayWithID() ID = %v, want %v", payment.ID, ID)
+	}
+	if found, err := s.FindPaymentByID(ID); err != nil || found != payment {
+		t.Errorf("FindPaymentByID() got = %v, %v", found, err)
+	}
+
+	_, err = s.PayWithID(ID, account.ID, 10, types.CategoryFood)
+	if !errors.Is(err, ErrDuplicatePaymentID) {
+		t.Errorf("PayWithID() error = %v, want %v", err, ErrDuplicatePaymentID)
+	}
+	for _, ID := range []string{"", " p1", "p;1", "p|1", "p\n1"} {
+		_, err = s.PayWithID(ID, account.ID, 10, types.CategoryFood)
+		if !errors.Is(err, ErrInvalidPaymentID) {
+			t.Errorf("PayWithID(%q) error = %v, want %v", ID, err, ErrInvalidPaymentID)
+		}
+	}
+	if account.Balance != 90 || len(s.payments) != 1 {
+		t.Errorf("PayWithID() balance = %v, payments = %v, want 90 and 1", account.Balance, len(s.payments))
+	}
+}
+
+func TestService_Reset(t *testing.T) {
+	s := NewService(WithMaxBalance(1000), WithIDGenerator(func() string { return "payment-1" }))
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account.ID, 10, types.CategoryFood)
+	_, _ = s.FavoritePayment(payment.ID, "food")
+	_ = s.SetDailyLimit(account.ID, 50)
+
+	s.Reset()
+	if len(s.accounts) != 0 || len(s.payments) != 0 || len(s.favorites) != 0 || len(s.dailyLimits) != 0 {
+		t.Errorf("Reset() left state = %v", s)
+	}
+	if _, err := s.FindPaymentByID(payment.ID); !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("FindPaymentByID() error = %v, want %v", err, ErrPaymentNotFound)
+	}
+
+	account, _ = s.AddAccountWithBalance("9127660305", 100)
+	payment, _ = s.Pay(account.ID, 10, types.CategoryFood)
+	if account.ID != 1 || payment.ID != "payment-1" || s.MaxBalance != 1000 {
+		t.Errorf("Reset() account = %v, payment = %v", account, payment)
+	}
+}
+
+func TestService_ImportFromFile_version(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		accounts int
+		wantErr  error
+	}{
+		{name: "v1", content: "v1\n1;9127660305;10|2;9127660306;11|", accounts: 2},
+		{name: "legacy without version", content: "1;9127660305;10|", accounts: 1},
+		{name: "unsupported version", content: "v2\n1;9127660305;10|", wantErr: ErrUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "accounts.txt")
+			err := ioutil.WriteFile(path, []byte(tt.content), 0644)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			s := newTestService()
+			err = s.ImportFromFile(path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ImportFromFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(s.accounts) != tt.accounts {
+				t.Errorf("ImportFromFile() accounts = %v, want %v", len(s.accounts), tt.accounts)
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+	s := newTestService()
+	_, _ = s.RegisterAccount("9127660305")
+	_ = s.ExportToFile(path)
+	content, _ := ioutil.ReadFile(path)
+	if !strings.HasPrefix(string(content), "v1\n") {
+		t.Errorf("ExportToFile() content = %q, want v1 header", content)
+	}
+}
+
+func TestService_RecategorizePayment(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account.ID, 10, types.CategoryShop)
+
+	err := s.RecategorizePayment("unknown", types.CategoryFood)
+	if !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("RecategorizePayment() error = %v, want %v", err, ErrPaymentNotFound)
+	}
+
+	err = s.RecategorizePayment(payment.ID, types.CategoryFood)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	spending, _ := s.SpendingByCategory(account.ID)
+	want := map[types.PaymentCategory]types.Money{types.CategoryFood: 10}
+	if !reflect.DeepEqual(spending, want) || account.Balance != 90 {
+		t.Errorf("SpendingByCategory() got = %v, balance = %v", spending, account.Balance)
+	}
+}
+
+func TestService_MonthlySummary(t *testing.T) {
+	now := time.Date(2021, 3, 10, 10, 0, 0, 0, time.UTC)
+	s := newTestService()
+	s.now = func() time.Time { return now }
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	_, _ = s.Pay(account.ID, 10, types.CategoryFood)
+	_, _ = s.Pay(account.ID, 20, types.CategoryFood)
+	_, _ = s.Pay(account.ID, 5, types.CategoryIt)
+	failed, _ := s.Pay(account.ID, 40, types.CategoryShop)
+	_ = s.Reject(failed.ID)
+
+	now = time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)
+	_ = s.Deposit(account.ID, 50)
+	_, _ = s.Pay(account.ID, 15, types.CategoryShop)
+
+	tests := []struct {
+		name      string
+		accountID int64
+		month     time.Month
+		want      Summary
+		wantErr   error
+	}{
+		{name: "account not found", accountID: 10, month: time.March, wantErr: ErrAccountNotFound},
+		{
+			name:      "march",
+			accountID: account.ID,
+			month:     time.March,
+			want: Summary{
+				Spent:      35,
+				Deposited:  100,
+				Payments:   3,
+				ByCategory: map[types.PaymentCategory]types.Money{types.CategoryFood: 30, types.CategoryIt: 5},
+			},
+		},
+		{
+			name:      "april",
+			accountID: account.ID,
+			month:     time.April,
+			want: Summary{
+				Spent:      15,
+				Deposited:  50,
+				Payments:   1,
+				ByCategory: map[types.PaymentCategory]types.Money{types.CategoryShop: 15},
+			},
+		},
+		{
+			name:      "no activity",
+			accountID: account.ID,
+			month:     time.May,
+			want:      Summary{ByCategory: map[types.PaymentCategory]types.Money{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.MonthlySummary(tt.accountID, 2021, tt.month)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("MonthlySummary() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MonthlySummary() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_Transactions(t *testing.T) {
+	s := newTestService()
+	s.FeeRate = 0.1
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	other, _ := s.AddAccountWithBalance("9127660306", 100)
+	payment, _ := s.Pay(account.ID, 20, types.CategoryFood)
+	_, _ = s.Pay(account.ID, 10, types.CategoryShop)
+	_ = s.Reject(payment.ID)
+	_ = s.Withdraw(account.ID, 5)
+	_, _ = s.Transfer(other.ID, account.ID, 40)
+
+	_, err := s.Transactions(10)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("Transactions() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	transactions, err := s.Transactions(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	kinds := make([]types.TransactionKind, 0, len(transactions))
+	var balance types.Money
+	for _, transaction := range transactions {
+		kinds = append(kinds, transaction.Kind)
+		balance += transaction.Amount
+	}
+	wantKinds := []types.TransactionKind{
+		types.TransactionDeposit,
+		types.TransactionPayment,
+		types.TransactionPayment,
+		types.TransactionRefund,
+		types.TransactionWithdrawal,
+		types.TransactionTransfer,
+	}
+	if !reflect.DeepEqual(kinds, wantKinds) {
+		t.Errorf("Transactions() kinds = %v, want %v", kinds, wantKinds)
+	}
+	got, _ := s.FindAccountByID(account.ID)
+	if balance != got.Balance {
+		t.Errorf("Transactions() sum = %v, want %v", balance, got.Balance)
+	}
+
+	path := filepath.Join(t.TempDir(), "export.txt")
+	if err := s.ExportToFile(path); err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	if err := imported.ImportFromFile(path); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(imported.transactions, s.transactions) {
+		t.Errorf("ImportFromFile() transactions = %v, want %v", imported.transactions, s.transactions)
+	}
+}
+
+func TestService_Validate(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	_, _ = s.Pay(account.ID, 10, types.CategoryFood)
+	_, _ = s.AddFavorite(account.ID, "food", 10, types.CategoryFood)
+	if err := s.Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+
+	s.accounts = append(s.accounts, &types.Account{ID: account.ID, Phone: account.Phone, Balance: -10})
+	s.payments = append(s.payments, &types.Payment{ID: "orphan", AccountID: 7})
+	s.favorites = append(s.favorites, &types.Favorite{ID: "lost", AccountID: 8})
+	err := s.Validate()
+	if !errors.Is(err, ErrInconsistentState) {
+		t.Errorf("Validate() error = %v, want %v", err, ErrInconsistentState)
+		return
+	}
+	for _, want := range []string{"duplicate id", "duplicate phone", "below overdraft limit", "payment orphan", "favorite lost"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error = %v, want it to mention %q", err, want)
+		}
+	}
+
+	s.nextAccountID = 0
+	if err := s.Validate(); err == nil || !strings.Contains(err.Error(), "next account id") {
+		t.Errorf("Validate() error = %v, want next account id violation", err)
+	}
+}
+
+func TestService_ExportTo_ImportFrom(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	_, _ = s.Pay(account.ID, 10, types.CategoryFood)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := s.ExportTo(gz); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	reader, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	if err := imported.ImportFrom(reader); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(imported.accounts, s.accounts) {
+		t.Errorf("ImportFrom() accounts = %v, want %v", imported.accounts, s.accounts)
+	}
+	if !reflect.DeepEqual(imported.payments, s.payments) {
+		t.Errorf("ImportFrom() payments = %v, want %v", imported.payments, s.payments)
+	}
+}
+
+func TestService_RegisterSubAccount(t *testing.T) {
+	s := newTestService()
+	account, _ := s.RegisterAccount("9127660305")
+
+	savings, err := s.RegisterSubAccount("912-766-0305", "savings")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if savings.ID == account.ID || savings.Phone != account.Phone || savings.Label != "savings" {
+		t.Errorf("RegisterSubAccount() got = %v, want new savings account for %v", savings, account.Phone)
+	}
+	if _, err := s.RegisterSubAccount("9127660305", "spending"); err != nil {
+		t.Error(err)
+	}
+	if _, err := s.RegisterSubAccount("9127660305", "savings"); !errors.Is(err, ErrLabelRegistered) {
+		t.Errorf("RegisterSubAccount() error = %v, want %v", err, ErrLabelRegistered)
+	}
+	if _, err := s.RegisterSubAccount("9127660305", ""); !errors.Is(err, ErrInvalidLabel) {
+		t.Errorf("RegisterSubAccount() error = %v, want %v", err, ErrInvalidLabel)
+	}
+	if _, err := s.RegisterAccount("9127660305"); !errors.Is(err, ErrPhoneRegistered) {
+		t.Errorf("RegisterAccount() error = %v, want %v", err, ErrPhoneRegistered)
+	}
+
+	_, _ = s.RegisterSubAccount("9127660306", "savings")
+	main, err := s.RegisterAccount("9127660306")
+	if err != nil || main.Label != "" {
+		t.Errorf("RegisterAccount() next to a sub-account got = %v, %v, want unlabelled account", main, err)
+	}
+	if _, err := s.RegisterAccount("9127660306"); !errors.Is(err, ErrPhoneRegistered) {
+		t.Errorf("RegisterAccount() error = %v, want %v", err, ErrPhoneRegistered)
+	}
+	if err := s.Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "export.txt")
+	if err := s.ExportToFile(path); err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	if err := imported.ImportFromFile(path); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(imported.accounts, s.accounts) {
+		t.Errorf("ImportFromFile() accounts = %v, want %v", imported.accounts, s.accounts)
+	}
+}
+
+func BenchmarkConcurrentDeposit(b *testing.B) {
+	s := newTestService()
+	for i := 1; i <= 100; i++ {
+		_, err := s.RegisterAccount(types.Phone(strconv.Itoa(i)))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			i++
+			err := s.Deposit(int64(i%100)+1, 1)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func TestService_Close(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	other, _ := s.AddAccountWithBalance("9127660306", 100)
+	payment, _ := s.Pay(account.ID, 10, types.CategoryFood)
+	transferID, _ := s.Transfer(account.ID, other.ID, 10)
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	_, _ = s.SchedulePayment(account.ID, 10, types.CategoryFood, now.Add(time.Hour))
+	_, _ = s.AddRecurring(other.ID, 20, types.CategoryShop, 24*time.Hour)
+	_ = s.SetDailyLimit(account.ID, 50)
+	_ = s.SetCategoryLimit(types.CategoryShop, 70)
+	path := filepath.Join(t.TempDir(), "export.txt")
+
+	if err := s.Close(path); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := s.Deposit(account.ID, 10); !errors.Is(err, ErrServiceClosed) {
+		t.Errorf("Deposit() error = %v, want %v", err, ErrServiceClosed)
+	}
+	if _, err := s.Pay(account.ID, 10, types.CategoryFood); !errors.Is(err, ErrServiceClosed) {
+		t.Errorf("Pay() error = %v, want %v", err, ErrServiceClosed)
+	}
+	if _, err := s.RegisterAccount("9127660307"); !errors.Is(err, ErrServiceClosed) {
+		t.Errorf("RegisterAccount() error = %v, want %v", err, ErrServiceClosed)
+	}
+	mutations := map[string]func() error{
+		"CanPay":          func() error { return s.CanPay(account.ID, 10) },
+		"ReverseTransfer": func() error { return s.ReverseTransfer(transferID) },
+		"Reject":          func() error { return s.Reject(payment.ID) },
+		"RejectWithRecord": func() error {
+			_, err := s.RejectWithRecord(payment.ID)
+			return err
+		},
+		"PartialRefund": func() error { return s.PartialRefund(payment.ID, 5) },
+		"RetryFailed": func() error {
+			_, err := s.RetryFailed(account.ID)
+			return err
+		},
+		"CloseAccount":      func() error { return s.CloseAccount(account.ID, other.ID) },
+		"MergeAccounts":     func() error { return s.MergeAccounts(account.ID, other.ID) },
+		"DeleteAccount":     func() error { return s.DeleteAccount(other.ID) },
+		"UpdatePhone":       func() error { return s.UpdatePhone(account.ID, "9127660307") },
+		"SetOverdraftLimit": func() error { return s.SetOverdraftLimit(account.ID, 100) },
+		"RestoreFromFile":   func() error { return s.RestoreFromFile(path) },
+		"ImportFrom":        func() error { return s.ImportFrom(strings.NewReader("v1\n")) },
+		"SetCategoryLimit":  func() error { return s.SetCategoryLimit(types.CategoryFood, 10) },
+		"ClearAuditLog":     s.ClearAuditLog,
+	}
+	for name, mutate := range mutations {
+		if err := mutate(); !errors.Is(err, ErrServiceClosed) {
+			t.Errorf("%s() error = %v, want %v", name, err, ErrServiceClosed)
+		}
+	}
+	if s.TotalBalance() != 190 || account.Balance != 80 {
+		t.Errorf("TotalBalance() = %v with balance %v, want 190 with balance 80", s.TotalBalance(), account.Balance)
+	}
+	if err := s.Close(path); !errors.Is(err, ErrServiceClosed) {
+		t.Errorf("Close() error = %v, want %v", err, ErrServiceClosed)
+	}
+
+	imported := newTestService()
+	if err := imported.ImportFromFile(path); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(imported.accounts, s.accounts) {
+		t.Errorf("ImportFromFile() accounts = %v, want %v", imported.accounts, s.accounts)
+	}
+	if !reflect.DeepEqual(imported.scheduled, s.scheduled) || !reflect.DeepEqual(imported.recurring, s.recurring) {
+		t.Errorf("ImportFromFile() scheduled = %v, recurring = %v, want %v and %v", imported.scheduled, imported.recurring, s.scheduled, s.recurring)
+	}
+	if !reflect.DeepEqual(imported.dailyLimits, s.dailyLimits) || !reflect.DeepEqual(imported.categoryLimits, s.categoryLimits) {
+		t.Errorf("ImportFromFile() limits = %v and %v, want %v and %v", imported.dailyLimits, imported.categoryLimits, s.dailyLimits, s.categoryLimits)
+	}
+	if len(s.auditLog) == 0 || s.categoryLimits[types.CategoryFood] != 0 {
+		t.Errorf("Close() audit log = %v, category limits = %v, want unchanged", s.auditLog, s.categoryLimits)
+	}
+
+	s.Reset()
+	if _, err := s.RegisterAccount("9127660305"); err != nil {
+		t.Errorf("RegisterAccount() error = %v after Reset, want nil", err)
+	}
+}
+
+func TestService_PaymentsBetween(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := newTestService()
+	s.now = func() time.Time { return now }
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	first, _ := s.Pay(account.ID, 10, types.CategoryFood)
+	now = now.Add(time.Hour)
+	second, _ := s.Pay(account.ID, 20, types.CategoryFood)
+	now = now.Add(time.Hour)
+	_, _ = s.Pay(account.ID, 30, types.CategoryFood)
+
+	from := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	got, err := s.PaymentsBetween(account.ID, from, now)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := []*types.Payment{first, second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PaymentsBetween() got = %v, want %v", got, want)
+	}
+
+	if _, err := s.PaymentsBetween(account.ID, now, from); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("PaymentsBetween() error = %v, want %v", err, ErrInvalidRange)
+	}
+	if _, err := s.PaymentsBetween(10, from, now); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("PaymentsBetween() error = %v, want %v", err, ErrAccountNotFound)
+	}
+}
+
+func TestService_MaxFavoritesPerAccount(t *testing.T) {
+	s := newTestService()
+	s.MaxFavoritesPerAccount = 2
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	other, _ := s.AddAccountWithBalance("9127660306", 100)
+	payment, _ := s.Pay(account.ID, 10, types.CategoryFood)
+
+	if _, err := s.AddFavorite(account.ID, "food", 10, types.CategoryFood); err != nil {
+		t.Error(err)
+	}
+	if _, err := s.FavoritePayment(payment.ID, "lunch"); err != nil {
+		t.Error(err)
+	}
+	if _, err := s.AddFavorite(account.ID, "shop", 10, types.CategoryShop); !errors.Is(err, ErrFavoriteLimitReached) {
+		t.Errorf("AddFavorite() error = %v, want %v", err, ErrFavoriteLimitReached)
+	}
+	if _, err := s.FavoritePayment(payment.ID, "dinner"); !errors.Is(err, ErrFavoriteLimitReached) {
+		t.Errorf("FavoritePayment() error = %v, want %v", err, ErrFavoriteLimitReached)
+	}
+	if _, err := s.AddFavorite(other.ID, "food", 10, types.CategoryFood); err != nil {
+		t.Errorf("AddFavorite() error = %v, want nil for another account", err)
+	}
+}
+
+func TestService_ImportFromFile_crlf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.txt")
+	content := "v1\r\n" +
+		"1; 9127660305 ;100;TJS;false;0;;\r\n|" +
+		"payment;p1;1;10;food;INPROGRESS;2021-03-01T10:00:00Z;lunch;0 \r\n|\r\n" +
+		" next;1|\r\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Error(err)
+		return
+	}
+
+	s := newTestService()
+	if err := s.ImportFromFile(path); err != nil {
+		t.Error(err)
+		return
+	}
+	account, err := s.FindAccountByID(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Phone != "9127660305" || account.Balance != 100 || account.Currency != "TJS" {
+		t.Errorf("ImportFromFile() account = %v, want 9127660305 with 100 TJS", account)
+	}
+	payment, err := s.FindPaymentByID("p1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if payment.Description != "lunch" || payment.Status != types.PaymentStatusInProgress {
+		t.Errorf("ImportFromFile() payment = %v, want in progress lunch payment", payment)
+	}
+}
+
+func TestService_AccountsRegisteredBetween(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := newTestService()
+	s.now = func() time.Time { return now }
+	first, _ := s.RegisterAccount("9127660305")
+	now = now.Add(24 * time.Hour)
+	second, _ := s.RegisterAccount("9127660306")
+	now = now.Add(24 * time.Hour)
+	_, _ = s.RegisterAccount("9127660307")
+
+	from := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	got := s.AccountsRegisteredBetween(from, from.Add(48*time.Hour))
+	want := []*types.Account{first, second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AccountsRegisteredBetween() got = %v, want %v", got, want)
+	}
+
+	path := filepath.Join(t.TempDir(), "wallet.json")
+	if err := s.ExportToJSON(path); err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	if err := imported.ImportFromJSON(path); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(imported.accounts, s.accounts) {
+		t.Errorf("ImportFromJSON() accounts = %v, want %v", imported.accounts, s.accounts)
+	}
+}
+
+func TestService_TopCategories(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 1_000)
+	other, _ := s.AddAccountWithBalance("9127660306", 1_000)
+	_, _ = s.Pay(account.ID, 30, types.CategoryFood)
+	_, _ = s.Pay(other.ID, 20, types.CategoryFood)
+	_, _ = s.Pay(account.ID, 40, types.CategoryShop)
+	_, _ = s.Pay(other.ID, 50, types.CategoryIt)
+	failed, _ := s.Pay(account.ID, 100, "auto")
+	_ = s.Reject(failed.ID)
+
+	tests := []struct {
+		name string
+		n    int
+		want []CategorySpend
+	}{
+		{name: "top two", n: 2, want: []CategorySpend{
+			{Category: types.CategoryFood, Total: 50},
+			{Category: types.CategoryIt, Total: 50},
+		}},
+		{name: "more than categories", n: 10, want: []CategorySpend{
+			{Category: types.CategoryFood, Total: 50},
+			{Category: types.CategoryIt, Total: 50},
+			{Category: types.CategoryShop, Total: 40},
+		}},
+		{name: "zero", n: 0, want: []CategorySpend{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.TopCategories(tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TopCategories() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_PayFromFavoriteAmount(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	favorite, _ := s.AddFavorite(account.ID, "food", 10, types.CategoryFood)
+
+	payment, err := s.PayFromFavoriteAmount(favorite.ID, 25)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if payment.AccountID != account.ID || payment.Amount != 25 || payment.Category != types.CategoryFood {
+		t.Errorf("PayFromFavoriteAmount() got = %v, want 25 food payment from %d", payment, account.ID)
+	}
+	if account.Balance != 75 {
+		t.Errorf("PayFromFavoriteAmount() balance = %v, want %v", account.Balance, 75)
+	}
+
+	if _, err := s.PayFromFavoriteAmount(favorite.ID, 0); !errors.Is(err, ErrAmountMustBePositive) {
+		t.Errorf("PayFromFavoriteAmount() error = %v, want %v", err, ErrAmountMustBePositive)
+	}
+	if _, err := s.PayFromFavoriteAmount(favorite.ID, 100); !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("PayFromFavoriteAmount() error = %v, want %v", err, ErrNotEnoughBalance)
+	}
+	if _, err := s.PayFromFavoriteAmount("unknown", 10); !errors.Is(err, ErrFavoriteNotFound) {
+		t.Errorf("PayFromFavoriteAmount() error = %v, want %v", err, ErrFavoriteNotFound)
+	}
+}
+
+func TestService_PayIfBalance(t *testing.T) {
+	s := newTestService()
+	s.FeeRate = 0.1
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+
+	if _, err := s.PayIfBalance(account.ID, 50, 50, types.CategoryFood); !errors.Is(err, ErrWouldGoBelowMinimum) {
+		t.Errorf("PayIfBalance() error = %v, want %v", err, ErrWouldGoBelowMinimum)
+	}
+	if account.Balance != 100 {
+		t.Errorf("PayIfBalance() balance = %v, want %v", account.Balance, 100)
+	}
+
+	payment, err := s.PayIfBalance(account.ID, 40, 56, types.CategoryFood)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if payment.Amount != 40 || account.Balance != 56 {
+		t.Errorf("PayIfBalance() got = %v with balance %v, want 40 with balance 56", payment, account.Balance)
+	}
+
+	if _, err := s.PayIfBalance(10, 10, 0, types.CategoryFood); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("PayIfBalance() error = %v, want %v", err, ErrAccountNotFound)
+	}
+}
+
+func TestService_ExportLedger(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := newTestService()
+	s.now = func() time.Time { return now }
+	ID := 0
+	s.idFunc = func() string {
+		ID++
+		return "id-" + strconv.Itoa(ID)
+	}
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	other, _ := s.AddAccountWithBalance("9127660306", 100)
+	_, _ = s.Pay(account.ID, 30, types.CategoryFood)
+	_, _ = s.Transfer(other.ID, account.ID, 20)
+	s.addAccount(&types.Account{ID: 3, Phone: "9127660307", Balance: 50})
+	_ = s.Deposit(3, 10)
+
+	var buf bytes.Buffer
+	if err := s.ExportLedger(10, &buf); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("ExportLedger() error = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	if err := s.ExportLedger(account.ID, &buf); err != nil {
+		t.Error(err)
+		return
+	}
+	want := "id,kind,amount,reference,created_at,balance\n" +
+		"1,deposit,100,,2021-03-01T10:00:00Z,100\n" +
+		"3,payment,-30,id-1,2021-03-01T10:00:00Z,70\n" +
+		"5,transfer,20,id-2,2021-03-01T10:00:00Z,90\n"
+	if buf.String() != want {
+		t.Errorf("ExportLedger() content = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := s.ExportLedger(3, &buf); err != nil {
+		t.Error(err)
+		return
+	}
+	want = "id,kind,amount,reference,created_at,balance\n" +
+		",opening,50,,,50\n" +
+		"6,deposit,10,,2021-03-01T10:00:00Z,60\n"
+	if buf.String() != want {
+		t.Errorf("ExportLedger() content = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestService_ReverseTransfer(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	other, _ := s.AddAccountWithBalance("9127660306", 100)
+
+	transferID, err := s.Transfer(account.ID, other.ID, 60)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	spentID, _ := s.Transfer(account.ID, other.ID, 40)
+	_, _ = s.Pay(other.ID, 150, types.CategoryFood)
+
+	if err := s.ReverseTransfer(transferID); !errors.Is(err, ErrNotEnoughBalance) {
+		t.Errorf("ReverseTransfer() error = %v, want %v", err, ErrNotEnoughBalance)
+	}
+	if err := s.ReverseTransfer(spentID); err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Balance != 40 || other.Balance != 10 {
+		t.Errorf("ReverseTransfer() balances = %v and %v, want 40 and 10", account.Balance, other.Balance)
+	}
+	if err := s.ReverseTransfer(spentID); !errors.Is(err, ErrTransferAlreadyReversed) {
+		t.Errorf("ReverseTransfer() error = %v, want %v", err, ErrTransferAlreadyReversed)
+	}
+	if err := s.ReverseTransfer("unknown"); !errors.Is(err, ErrTransferNotFound) {
+		t.Errorf("ReverseTransfer() error = %v, want %v", err, ErrTransferNotFound)
+	}
+}
+
+func TestService_ReverseTransfer_closed(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	other, _ := s.AddAccountWithBalance("9127660306", 100)
+	third, _ := s.RegisterAccount("9127660307")
+
+	transferID, _ := s.Transfer(account.ID, other.ID, 50)
+	_ = s.CloseAccount(account.ID, third.ID)
+
+	if err := s.ReverseTransfer(""); !errors.Is(err, ErrTransferNotFound) {
+		t.Errorf("ReverseTransfer() error = %v, want %v", err, ErrTransferNotFound)
+	}
+	sweepID := s.transactions[len(s.transactions)-1].Reference
+	for _, ID := range []string{sweepID, transferID} {
+		if err := s.ReverseTransfer(ID); !errors.Is(err, ErrAccountClosed) {
+			t.Errorf("ReverseTransfer(%q) error = %v, want %v", ID, err, ErrAccountClosed)
+		}
+	}
+	if account.Balance != 0 || other.Balance != 150 || third.Balance != 50 {
+		t.Errorf("ReverseTransfer() balances = %v, %v and %v, want 0, 150 and 50", account.Balance, other.Balance, third.Balance)
+	}
+}
+
+func TestService_AllPayments(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := newTestService()
+	s.now = func() time.Time { return now }
+	IDs := []string{"c", "a", "b"}
+	s.idFunc = func() string {
+		ID := IDs[0]
+		IDs = IDs[1:]
+		return ID
+	}
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	other, _ := s.AddAccountWithBalance("9127660306", 100)
+	c, _ := s.Pay(account.ID, 10, types.CategoryFood)
+	now = now.Add(-time.Hour)
+	a, _ := s.Pay(other.ID, 10, types.CategoryFood)
+	now = now.Add(2 * time.Hour)
+	b, _ := s.Pay(account.ID, 10, types.CategoryFood)
+	b.CreatedAt = c.CreatedAt
+
+	tests := []struct {
+		name   string
+		offset int
+		limit  int
+		want   []*types.Payment
+	}{
+		{name: "first page", offset: 0, limit: 2, want: []*types.Payment{a, b}},
+		{name: "second page", offset: 2, limit: 2, want: []*types.Payment{c}},
+		{name: "negative offset", offset: -1, limit: 1, want: []*types.Payment{a}},
+		{name: "default limit", offset: 1, limit: -1, want: []*types.Payment{b, c}},
+		{name: "past the end", offset: 5, limit: 2, want: []*types.Payment{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.AllPayments(tt.offset, tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AllPayments() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_OnLowBalance(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+
+	low := make([]types.Money, 0)
+	empty := 0
+	s.OnLowBalance(50, func(account *types.Account) {
+		low = append(low, account.Balance)
+	})
+	s.OnLowBalance(10, func(account *types.Account) {
+		empty++
+		_ = s.Deposit(account.ID, 100)
+	})
+
+	_, _ = s.Pay(account.ID, 40, types.CategoryFood)
+	if len(low) != 0 {
+		t.Errorf("OnLowBalance() calls = %v, want none above threshold", low)
+	}
+	_, _ = s.Pay(account.ID, 20, types.CategoryFood)
+	_ = s.Withdraw(account.ID, 10)
+	if !reflect.DeepEqual(low, []types.Money{40}) {
+		t.Errorf("OnLowBalance() calls = %v, want %v", low, []types.Money{40})
+	}
+	_, _ = s.Pay(account.ID, 100, types.CategoryFood)
+	_ = s.Withdraw(account.ID, 25)
+	if empty != 1 || account.Balance != 105 {
+		t.Errorf("OnLowBalance() top ups = %v with balance %v, want 1 with balance 105", empty, account.Balance)
+	}
+}
+
+func TestService_OnLowBalance_entryPoints(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	favorite, _ := s.AddFavorite(account.ID, "rent", 60, types.CategoryShop)
+
+	calls := 0
+	s.OnLowBalance(50, func(account *types.Account) {
+		calls++
+		_ = s.Deposit(account.ID, 60)
+	})
+
+	_, err := s.PayFromFavorite(favorite.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if calls != 1 || account.Balance != 100 {
+		t.Errorf("PayFromFavorite() calls = %v, balance = %v, want 1 and 100", calls, account.Balance)
+	}
+
+	now := time.Now()
+	_, _ = s.SchedulePayment(account.ID, 60, types.CategoryShop, now)
+	_, _ = s.RunDue(now)
+	if calls != 2 || account.Balance != 100 {
+		t.Errorf("RunDue() calls = %v, balance = %v, want 2 and 100", calls, account.Balance)
+	}
+
+	err = s.Batch([]Operation{
+		{Kind: OperationPay, AccountID: account.ID, Amount: 60, Category: types.CategoryShop},
+		{Kind: OperationPay, AccountID: account.ID, Amount: 60, Category: types.CategoryShop},
+	})
+	if !errors.Is(err, ErrNotEnoughBalance) || calls != 2 {
+		t.Errorf("Batch() error = %v, calls = %v, want %v and 2", err, calls, ErrNotEnoughBalance)
+	}
+	err = s.Batch([]Operation{{Kind: OperationPay, AccountID: account.ID, Amount: 60, Category: types.CategoryShop}})
+	if err != nil || calls != 3 || account.Balance != 100 {
+		t.Errorf("Batch() error = %v, calls = %v, balance = %v, want 3 and 100", err, calls, account.Balance)
+	}
+
+	payment, _ := s.Pay(account.ID, 10, types.CategoryShop)
+	_ = s.Reject(payment.ID)
+	_ = s.Withdraw(account.ID, 45)
+	succeeded, err := s.RetryFailed(account.ID)
+	if err != nil || succeeded != 1 || calls != 4 || account.Balance != 105 {
+		t.Errorf("RetryFailed() = %v, %v, calls = %v, balance = %v, want 1, 4 and 105", succeeded, err, calls, account.Balance)
+	}
+}
+
+func TestService_EnsureAccount(t *testing.T) {
+	s := newTestService()
+
+	account, created, err := s.EnsureAccount("9127660305")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !created || account.Phone != "9127660305" {
+		t.Errorf("EnsureAccount() got = %v, %v, want new account", account, created)
+	}
+
+	again, created, err := s.EnsureAccount("912-766-0305")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if created || again != account {
+		t.Errorf("EnsureAccount() got = %v, %v, want existing %v", again, created, account)
+	}
+	if len(s.accounts) != 1 {
+		t.Errorf("len(accounts) = %v, want %v", len(s.accounts), 1)
+	}
+
+	savings, _ := s.RegisterSubAccount("9127660306", "savings")
+	main, created, err := s.EnsureAccount("9127660306")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !created || main == savings || main.Label != "" || main.Phone != "9127660306" {
+		t.Errorf("EnsureAccount() got = %v, %v, want new unlabelled account next to %v", main, created, savings)
+	}
+
+	s.MaxAccounts = 3
+	if _, _, err := s.EnsureAccount("9127660307"); !errors.Is(err, ErrAccountLimitReached) {
+		t.Errorf("EnsureAccount() error = %v, want %v", err, ErrAccountLimitReached)
+	}
+}
+
+func TestService_MinDeposit(t *testing.T) {
+	s := NewService(WithMinDeposit(10))
+	account, _ := s.RegisterAccount("9127660305")
+
+	tests := []struct {
+		name    string
+		amount  types.Money
+		wantErr error
+	}{
+		{name: "zero", amount: 0, wantErr: ErrAmountMustBePositive},
+		{name: "below minimum", amount: 9, wantErr: ErrDepositTooSmall},
+		{name: "minimum", amount: 10, wantErr: nil},
+		{name: "above minimum", amount: 11, wantErr: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.Deposit(account.ID, tt.amount); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Deposit() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if account.Balance != 21 {
+		t.Errorf("Deposit() balance = %v, want %v", account.Balance, 21)
+	}
+}
+
+func TestService_ReassignAccount(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	_, _ = s.RegisterAccount("9127660306")
+	s.ClearAuditLog()
+
+	if err := s.ReassignAccount(account.ID, "912-766-0306"); !errors.Is(err, ErrPhoneRegistered) {
+		t.Errorf("ReassignAccount() error = %v, want %v", err, ErrPhoneRegistered)
+	}
+	if err := s.ReassignAccount(10, "9127660307"); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("ReassignAccount() error = %v, want %v", err, ErrAccountNotFound)
+	}
+	if err := s.ReassignAccount(account.ID, "912-766-0307"); err != nil {
+		t.Error(err)
+		return
+	}
+	if account.Phone != "9127660307" || account.Balance != 100 {
+		t.Errorf("ReassignAccount() account = %v, want phone 9127660307 with balance 100", account)
+	}
+
+	log := s.AuditLog()
+	if len(log) != 1 || log[0].Operation != OperationReassign || log[0].AccountID != account.ID {
+		t.Errorf("AuditLog() = %v, want one reassign entry for %d", log, account.ID)
+		return
+	}
+	if want := "from 9127660305 to 9127660307"; log[0].Detail != want {
+		t.Errorf("AuditLog() detail = %q, want %q", log[0].Detail, want)
+	}
+}
+
+func TestService_AmountPercentile(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 1_000)
+	empty, _ := s.RegisterAccount("9127660306")
+	for _, amount := range []types.Money{40, 10, 30, 20} {
+		_, _ = s.Pay(account.ID, amount, types.CategoryFood)
+	}
+	failed, _ := s.Pay(account.ID, 500, types.CategoryFood)
+	_ = s.Reject(failed.ID)
+
+	tests := []struct {
+		name      string
+		accountID int64
+		p         float64
+		want      types.Money
+		wantErr   error
+	}{
+		{name: "minimum", accountID: account.ID, p: 0, want: 10},
+		{name: "median", accountID: account.ID, p: 50, want: 20},
+		{name: "high", accountID: account.ID, p: 90, want: 40},
+		{name: "maximum", accountID: account.ID, p: 100, want: 40},
+		{name: "no payments", accountID: empty.ID, p: 50, want: 0},
+		{name: "below range", accountID: account.ID, p: -1, wantErr: ErrInvalidPercentile},
+		{name: "above range", accountID: account.ID, p: 101, wantErr: ErrInvalidPercentile},
+		{name: "unknown account", accountID: 10, p: 50, wantErr: ErrAccountNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.AmountPercentile(tt.accountID, tt.p)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("AmountPercentile() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("AmountPercentile() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_RejectWithRecord_exportRoundTrip(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account.ID, 40, types.CategoryFood)
+	if _, err := s.RejectWithRecord(payment.ID); err != nil {
+		t.Error(err)
+		return
+	}
+
+	path := filepath.Join(t.TempDir(), "export.txt")
+	if err := s.ExportToFile(path); err != nil {
+		t.Error(err)
+		return
+	}
+	restored := newTestService()
+	if err := restored.RestoreFromFile(path); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(restored.reversals, s.reversals) {
+		t.Errorf("RestoreFromFile() reversals = %v, want %v", restored.reversals, s.reversals)
+	}
+	if err := restored.Reject(payment.ID); !errors.Is(err, ErrPaymentAlreadyRejected) {
+		t.Errorf("Reject() error = %v, want %v", err, ErrPaymentAlreadyRejected)
+	}
+	if got, _ := restored.FindAccountByID(account.ID); got.Balance != 100 {
+		t.Errorf("Reject() balance = %v, want %v", got.Balance, 100)
+	}
+}
+
+func TestService_PartialRefund_exportRoundTrip(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	payment, _ := s.Pay(account.ID, 40, types.CategoryFood)
+	s.ClearAuditLog()
+	if err := s.PartialRefund(payment.ID, 30); err != nil {
+		t.Error(err)
+		return
+	}
+	wantLog := []AuditEntry{{Operation: OperationRefund, AccountID: account.ID, Amount: 30}}
+	gotLog := s.AuditLog()
+	for i := range gotLog {
+		gotLog[i].Time = time.Time{}
+	}
+	if !reflect.DeepEqual(gotLog, wantLog) {
+		t.Errorf("AuditLog() = %v, want %v", gotLog, wantLog)
+	}
+
+	path := filepath.Join(t.TempDir(), "export.txt")
+	if err := s.ExportToFile(path); err != nil {
+		t.Error(err)
+		return
+	}
+	restored := newTestService()
+	if err := restored.RestoreFromFile(path); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(restored.refunds, s.refunds) {
+		t.Errorf("RestoreFromFile() refunds = %v, want %v", restored.refunds, s.refunds)
+	}
+	if err := restored.Reject(payment.ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if got, _ := restored.FindAccountByID(account.ID); got.Balance != 100 {
+		t.Errorf("Reject() balance = %v, want %v", got.Balance, 100)
+	}
+}
+
+func TestService_ReverseTransfer_merged(t *testing.T) {
+	idFunc := func() string { return "transfer-1" }
+	incoming := NewService(WithIDGenerator(idFunc))
+	first, _ := incoming.AddAccountWithBalance("9127660307", 100)
+	second, _ := incoming.AddAccountWithBalance("9127660308", 100)
+	_, _ = incoming.Transfer(first.ID, second.ID, 5)
+	path := filepath.Join(t.TempDir(), "export.txt")
+	if err := incoming.ExportToFile(path); err != nil {
+		t.Error(err)
+		return
+	}
+
+	s := NewService(WithIDGenerator(idFunc))
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	other, _ := s.AddAccountWithBalance("9127660306", 100)
+	transferID, _ := s.Transfer(account.ID, other.ID, 5)
+	if _, _, err := s.MergeFromFile(path); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := s.ReverseTransfer(transferID); !errors.Is(err, ErrAmbiguousTransfer) {
+		t.Errorf("ReverseTransfer() error = %v, want %v", err, ErrAmbiguousTransfer)
+	}
+	if s.TotalBalance() != 400 || account.Balance != 95 || other.Balance != 105 {
+		t.Errorf("ReverseTransfer() balances = %v and %v, want 95 and 105", account.Balance, other.Balance)
+	}
+}
+
+func TestService_DeleteAccount_favoriteDestination(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	other, _ := s.RegisterAccount("9127660306")
+	transfer, _ := s.AddFavorite(account.ID, "savings", 10, types.CategoryFood)
+	_ = s.SetFavoriteDestination(transfer.ID, other.ID)
+	food, _ := s.AddFavorite(account.ID, "food", 10, types.CategoryFood)
+
+	if err := s.DeleteAccount(other.ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if want := []*types.Favorite{food}; !reflect.DeepEqual(s.favorites, want) {
+		t.Errorf("DeleteAccount() favorites = %v, want %v", s.favorites, want)
+	}
+	if err := s.Validate(); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+}
+
+func TestService_DeleteAccount_schedules(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	other, _ := s.AddAccountWithBalance("9127660306", 100)
+	now := time.Now()
+	_, _ = s.SchedulePayment(account.ID, 10, types.CategoryFood, now)
+	_, _ = s.AddRecurring(account.ID, 10, types.CategoryFood, time.Hour)
+	scheduleID, _ := s.SchedulePayment(other.ID, 10, types.CategoryFood, now)
+	recurringID, _ := s.AddRecurring(other.ID, 10, types.CategoryFood, time.Hour)
+
+	err := s.DeleteAccount(account.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(s.scheduled) != 1 || s.scheduled[0].ID != scheduleID {
+		t.Errorf("DeleteAccount() scheduled = %v, want only %v", s.scheduled, scheduleID)
+	}
+	if len(s.recurring) != 1 || s.recurring[0].ID != recurringID {
+		t.Errorf("DeleteAccount() recurring = %v, want only %v", s.recurring, recurringID)
+	}
+
+	payments, _ := s.RunDue(now)
+	s.TickRecurring(now.Add(2 * time.Hour))
+	if len(payments) != 1 || other.Balance != 80 {
+		t.Errorf("DeleteAccount() payments = %v, balance = %v, want 1 and 80", payments, other.Balance)
+	}
+}
+
+func TestService_ExportTo_ImportFrom_spaces(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	_, _ = s.RegisterSubAccount("9127660305", " savings ")
+	_, _ = s.PayWithNote(account.ID, 10, types.CategoryFood, " gift ")
+	s.record(account.ID, types.TransactionDeposit, 10, " cash ")
+
+	var buf bytes.Buffer
+	if err := s.ExportTo(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	if err := imported.ImportFrom(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+	if imported.accounts[1].Label != " savings " {
+		t.Errorf("ImportFrom() label = %q, want %q", imported.accounts[1].Label, " savings ")
+	}
+	if imported.payments[0].Description != " gift " {
+		t.Errorf("ImportFrom() description = %q, want %q", imported.payments[0].Description, " gift ")
+	}
+	if last := imported.transactions[len(imported.transactions)-1]; last.Reference != " cash " {
+		t.Errorf("ImportFrom() reference = %q, want %q", last.Reference, " cash ")
+	}
+}
+
+func TestService_Export_favoriteName(t *testing.T) {
+	s := newTestService()
+	account, _ := s.AddAccountWithBalance("9127660305", 100)
+	favorite, _ := s.AddFavorite(account.ID, "rent; flat | 5%", 10, types.CategoryShop)
+
+	var buf bytes.Buffer
+	if err := s.ExportTo(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+	imported := newTestService()
+	if err := imported.ImportFrom(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(imported.favorites, s.favorites) {
+		t.Errorf("ImportFrom() favorites = %v, want %v", imported.favorites, s.favorites)
+	}
+
+	dir := t.TempDir()
+	if err := s.Export(dir); err != nil {
+		t.Error(err)
+		return
+	}
+	restored := newTestService()
+	if err := restored.Import(dir); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(restored.favorites) != 1 || restored.favorites[0].Name != favorite.Name {
+		t.Errorf("Import() favorites = %v, want name %q", restored.favorites, favorite.Name)
+	}
+	if err := restored.Import(dir); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(restored.favorites) != 1 || restored.favorites[0].Name != favorite.Name {
+		t.Errorf("Import() again favorites = %v, want name %q", restored.favorites, favorite.Name)
+	}
+}
+
+func TestService_MaxBalance_credits(t *testing.T) {
+	s := NewService(WithMaxBalance(100))
+	full, _ := s.AddAccountWithBalance("9127660305", 100)
+	other, _ := s.AddAccountWithBalance("9127660306", 100)
+	payment, _ := s.Pay(other.ID, 60, types.CategoryFood)
+	_ = s.Deposit(other.ID, 10)
+	transferID, _ := s.Transfer(full.ID, other.ID, 50)
+	_ = s.Deposit(full.ID, 50)
+
+	credits := map[string]func() error{
+		"Transfer": func() error {
+			_, err := s.Transfer(other.ID, full.ID, 10)
+			return err
+		},
+		"ReverseTransfer": func() error { return s.ReverseTransfer(transferID) },
+		"Reject":          func() error { return s.Reject(payment.ID) },
+		"RejectWithRecord": func() error {
+			_, err := s.RejectWithRecord(payment.ID)
+			return err
+		},
+		"PartialRefund": func() error { return s.PartialRefund(payment.ID, 60) },
+		"CloseAccount":  func() error { return s.CloseAccount(other.ID, full.ID) },
+		"MergeAccounts": func() error { return s.MergeAccounts(full.ID, other.ID) },
+	}
+	for name, credit := range credits {
+		if err := credit(); !errors.Is(err, ErrBalanceLimitExceeded) {
+			t.Errorf("%s() error = %v, want %v", name, err, ErrBalanceLimitExceeded)
+		}
+	}
+	if full.Balance != 100 || other.Balance != 100 {
+		t.Errorf("balances = %v and %v, want 100 and 100", full.Balance, other.Balance)
+	}
+}